@@ -4,4 +4,7 @@ const (
 	HeaderRetryAfter          = "retry-after"
 	HeaderXRateLimitReset     = "x-ratelimit-reset"
 	HeaderXRateLimitRemaining = "x-ratelimit-remaining"
+	HeaderXRateLimitResource  = "x-ratelimit-resource"
+	HeaderContentLength       = "content-length"
+	HeaderContentType         = "content-type"
 )
@@ -0,0 +1,110 @@
+package github_ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// eventBatcher buffers the limit events and delivers them in batches (see WithBatchedEvents).
+// the batches are delivered by a single goroutine, so the handler is never called concurrently.
+type eventBatcher struct {
+	lock      sync.Mutex
+	pending   []LimitEvent
+	maxBatch  int
+	handler   LimitEventsHandler
+	full      chan struct{}
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newEventBatcher creates the batcher and starts flushing it at the configured interval.
+// returns nil if batched events are not configured.
+func newEventBatcher(ctx context.Context, config *SecondaryRateLimitConfig) *eventBatcher {
+	if config.batchHandler == nil {
+		return nil
+	}
+
+	b := &eventBatcher{
+		maxBatch: config.batchMax,
+		handler:  config.batchHandler,
+		full:     make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run(ctx, config.batchInterval)
+	return b
+}
+
+// run flushes the batch at every interval, whenever it is full, and once the batcher is closed.
+func (b *eventBatcher) run(ctx context.Context, interval time.Duration) {
+	defer close(b.done)
+
+	// a non-positive interval means the batches are flushed only when full (or closed)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			b.flush()
+		case <-b.full:
+			b.flush()
+		case <-b.closed:
+			b.flush()
+			return
+		case <-ctx.Done():
+			b.flush()
+			return
+		}
+	}
+}
+
+// add buffers the event, and signals a flush if the batch is full.
+func (b *eventBatcher) add(event LimitEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.pending = append(b.pending, event)
+	if b.maxBatch > 0 && len(b.pending) >= b.maxBatch {
+		select {
+		case b.full <- struct{}{}:
+		default:
+			// a flush is already signaled
+		}
+	}
+}
+
+// flush delivers the buffered events (if any).
+func (b *eventBatcher) flush() {
+	b.lock.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.lock.Unlock()
+
+	if len(batch) > 0 {
+		b.handler(batch)
+	}
+}
+
+// close flushes the remaining events and stops the batcher.
+func (b *eventBatcher) close() {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+	<-b.done
+}
+
+// Close releases the resources of the waiter, i.e., flushes the remaining batched events (see WithBatchedEvents).
+// The waiter must not be used after it is closed.
+func (t *SecondaryRateLimitWaiter) Close() error {
+	if t.batcher != nil {
+		t.batcher.close()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package github_ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// operationBudget is the remaining wait budget of a logical operation (see WithOperationBudget).
+type operationBudget struct {
+	lock      sync.Mutex
+	remaining time.Duration
+}
+
+// fits checks whether the wait fits in the remaining budget.
+func (b *operationBudget) fits(wait time.Duration) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return wait <= b.remaining
+}
+
+// consume subtracts the time that was actually waited from the remaining budget.
+func (b *operationBudget) consume(waited time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.remaining -= waited
+}
+
+type operationBudgetKey struct{}
+
+// WithOperationBudget adds a wait budget for a logical operation to the context.
+// All requests issued with the context (or its descendants) share the budget,
+// and every wait of the waiter is subtracted from it (i.e., secondary rate limit sleeps,
+// as well as pacing waits and service unavailability backoffs), by the time that was actually waited.
+// Once a wait would exceed the remaining budget, the request fails fast with ErrOperationBudgetExceeded.
+func WithOperationBudget(ctx context.Context, budget time.Duration) context.Context {
+	return context.WithValue(ctx, operationBudgetKey{}, &operationBudget{remaining: budget})
+}
+
+// getOperationBudget returns the operation budget in the context, or nil if there is none.
+func getOperationBudget(ctx context.Context) *operationBudget {
+	budget, _ := ctx.Value(operationBudgetKey{}).(*operationBudget)
+	return budget
+}
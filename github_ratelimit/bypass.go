@@ -0,0 +1,48 @@
+package github_ratelimit
+
+import (
+	"context"
+	"net/http"
+)
+
+type bypassKey struct{}
+
+// WithBypass marks the requests issued with the context to bypass the waiter (similar to github.BypassRateLimitCheck),
+// i.e., they are sent right away, even during an active secondary rate limit, and are never retried.
+// The responses are still inspected, so a detected secondary rate limit triggers the callbacks and pauses other requests.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+// isBypassed checks whether the context is marked to bypass the waiter (see WithBypass).
+func isBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassKey{}).(bool)
+	return bypass
+}
+
+// roundTripBypass issues the request without waiting or retrying (see WithBypass).
+func (t *SecondaryRateLimitWaiter) roundTripBypass(config *SecondaryRateLimitConfig, request *http.Request, decision *LimitDecision) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(request)
+	if err != nil {
+		return resp, err
+	}
+
+	if config.responseInspector != nil {
+		config.responseInspector(request, resp)
+	}
+
+	secondaryLimit, _ := t.detectSecondaryLimit(config, request, resp)
+	if secondaryLimit == nil {
+		config.recordDecision(request, resp, nil, DecisionPass, 0)
+		decision.Decision = DecisionPass
+		attachLimitDecision(request, resp, decision)
+		return resp, nil
+	}
+
+	decision.limited(resp, *secondaryLimit, config.getMillisecondResetThreshold())
+	t.updateRateLimit(*secondaryLimit, config, t.newCallbackContext(request, resp))
+	config.recordDecision(request, resp, secondaryLimit, DecisionReturn, 0)
+	decision.Decision = DecisionReturn
+	attachLimitDecision(request, resp, decision)
+	return resp, nil
+}
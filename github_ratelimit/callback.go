@@ -13,11 +13,13 @@ type CallbackContext struct {
 	TotalSleepTime *time.Duration
 	Request        *http.Request
 	Response       *http.Response
+	Metadata       map[string]any
 }
 
 // OnLimitDetected is a callback to be called when a new rate limit is detected (before the sleep)
 // The totalSleepTime includes the sleep duration for the upcoming sleep
-// Note: called while holding the lock.
+// The callback may modify the sleepUntil (e.g., to add a safety margin), and the sleep honors the modified time.
+// Note: called just after the transition (without holding the lock), so the callback may use the waiter.
 type OnLimitDetected func(*CallbackContext)
 
 // OnSingleLimitPassed is a callback to be called when a rate limit is exceeding the limit for a single sleep.
@@ -31,3 +33,57 @@ type OnSingleLimitExceeded func(*CallbackContext)
 // The totalSleepTime does not include the sleep (that is not going to happen).
 // Note: called while holding the lock.
 type OnTotalLimitExceeded func(*CallbackContext)
+
+// OnWindowBudgetExceeded is a callback to be called when a rate limit is exceeding the sleep budget of the window.
+// The sleepUntil represents the end of sleep duration if the budget was not exceeded.
+// Note: called while holding the lock.
+type OnWindowBudgetExceeded func(*CallbackContext)
+
+// OnRecovered is a callback to be called when a request succeeds after one or more secondary rate limit retries.
+// The totalWaited is the total time the request waited before being sent (across all attempts).
+// The callback context includes the round tripper, the request and the (non-limited) response.
+type OnRecovered func(callbackContext *CallbackContext, totalWaited time.Duration)
+
+// OnSlipped is a callback to be called when a request got a secondary rate limit response while a limit was already active,
+// i.e., the request was sent (concurrently) before the active limit was detected (see Stats).
+// The callback context includes the round tripper, the request and the response.
+type OnSlipped func(*CallbackContext)
+
+// OnServiceUnavailable is a callback to be called when a request got a transient service unavailability response
+// (see WithServiceUnavailableBackoff), before backing off. The sleepUntil represents the end of the back off.
+// The totalSleepTime is nil, as the back off is not accounted as a secondary rate limit sleep.
+type OnServiceUnavailable func(*CallbackContext)
+
+// OnSecondaryStateChange is a callback to be called when a secondary rate limit becomes active (until resetTime),
+// and when it clears (either when the reset time passes or when the limit is released early, see WithProbeRetry).
+// It allows publishing the state, e.g., for the coordination of several processes.
+// Note: called while holding the lock.
+type OnSecondaryStateChange func(active bool, resetTime time.Time)
+
+// LimitEventsHandler is a callback to be called with a batch of limit events (see WithBatchedEvents).
+type LimitEventsHandler func([]LimitEvent)
+
+// ResponseInspector is a callback to be called on every response, before the rate limit detection.
+// It is called for every attempt, i.e., retried requests trigger it once per response.
+// Note: mutating the response (and specifically reading/replacing the body) is unsupported.
+type ResponseInspector func(*http.Request, *http.Response)
+
+// ResponseModifier is a callback to be called on the response right before it is returned (e.g., to strip headers).
+// Note: the body must remain readable (i.e., it may be replaced, but not consumed).
+type ResponseModifier func(*http.Response)
+
+// RequestFilter decides whether the secondary rate limit detection applies to the request.
+// Returning false skips the detection, so the response is returned untouched (e.g., for huge non-JSON bodies).
+type RequestFilter func(*http.Request) bool
+
+// PreSendCallback is a callback to be called right before a request is sent (after waiting for any active limit).
+// The waited parameter is the time the request waited for the waiter before being sent.
+// It is called for every attempt, i.e., retried requests trigger it once per attempt.
+type PreSendCallback func(request *http.Request, waited time.Duration)
+
+// ThrottleKey returns the key that the request is throttled by (see WithThrottleKey), e.g., the installation ID.
+type ThrottleKey func(*http.Request) string
+
+// RetryDecider decides whether a request that got a secondary rate limit response should be retried (after sleeping).
+// Returning false returns the response as-is instead, e.g., to avoid replaying non-idempotent requests.
+type RetryDecider func(*http.Request, *http.Response) bool
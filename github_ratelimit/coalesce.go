@@ -0,0 +1,78 @@
+package github_ratelimit
+
+import (
+	"context"
+)
+
+// coalesceGate lets a single request (the leader) resume once a secondary rate limit is over,
+// while the other requests (followers) wait for it to complete (see WithCoalescing).
+type coalesceGate struct {
+	leading bool
+	done    chan struct{}
+}
+
+// newCoalesceGate creates the gate for a new rate limit.
+// returns nil if coalescing is not configured.
+func newCoalesceGate(config *SecondaryRateLimitConfig) *coalesceGate {
+	if !config.coalescing {
+		return nil
+	}
+	return &coalesceGate{
+		done: make(chan struct{}),
+	}
+}
+
+// claimLeader attempts to claim the leadership of the gate of the last rate limit.
+// returns the gate if the caller is the leader (or nil if there is no gate),
+// or otherwise, a channel that is closed once the leader completes.
+func (t *SecondaryRateLimitWaiter) claimLeader() (*coalesceGate, <-chan struct{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	gate := t.coalesce
+	if gate == nil {
+		return nil, nil
+	}
+
+	if !gate.leading {
+		gate.leading = true
+		return gate, nil
+	}
+
+	return nil, gate.done
+}
+
+// finishLeader completes the leader's request (nil for requests that are not leaders) and releases the followers.
+// if the request was never sent (e.g., its context is done), a new gate is opened so that another request could lead.
+func (t *SecondaryRateLimitWaiter) finishLeader(gate *coalesceGate, sent bool) {
+	if gate == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// a newer rate limit may have replaced the gate in the meantime
+	if t.coalesce == gate {
+		if sent {
+			t.coalesce = nil
+		} else {
+			t.coalesce = &coalesceGate{done: make(chan struct{})}
+		}
+	}
+
+	close(gate.done)
+}
+
+// waitForLeader waits for the leader to complete.
+// returns an error if either the request context or the root context is done.
+func (t *SecondaryRateLimitWaiter) waitForLeader(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
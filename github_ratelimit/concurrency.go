@@ -0,0 +1,39 @@
+package github_ratelimit
+
+import (
+	"context"
+)
+
+// newConcurrencySlots creates the slots for the in-flight requests (see WithHardConcurrencyLimit).
+// returns nil if no concurrency limit is configured.
+func newConcurrencySlots(config *SecondaryRateLimitConfig) chan struct{} {
+	if config.concurrencyLimit <= 0 {
+		return nil
+	}
+	return make(chan struct{}, config.concurrencyLimit)
+}
+
+// acquireConcurrencySlot waits for a free slot for an in-flight request (see WithHardConcurrencyLimit).
+// returns a function that releases the slot (a no-op if there is no concurrency limit).
+// returns an error if either the request context or the root context is done.
+func (t *SecondaryRateLimitWaiter) acquireConcurrencySlot(ctx context.Context) (func(), error) {
+	if t.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+		return func() { <-t.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.ctx.Done():
+		return nil, t.ctx.Err()
+	}
+}
+
+// isLimitActive returns true if a secondary rate limit is currently active.
+func (t *SecondaryRateLimitWaiter) isLimitActive() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.currentSleepDurationUnlocked() > 0
+}
@@ -2,6 +2,9 @@ package github_ratelimit
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -9,13 +12,73 @@ import (
 // Use the options to set the config.
 type SecondaryRateLimitConfig struct {
 	// limits
-	singleSleepLimit *time.Duration
-	totalSleepLimit  *time.Duration
+	singleSleepLimit  *time.Duration
+	totalSleepLimit   *time.Duration
+	windowSleepBudget *time.Duration
+	sleepBudgetWindow time.Duration
+	minSleep          time.Duration
+	maxResetHorizon   *time.Duration
+	preciseSleep      bool
+	globalMaxRetries  *int64
+	limitAsError      bool
+	safeRetryMethods  []string
+
+	// detection
+	resetPreference  ResetPreference
+	lenientDetection bool
+	statusCodes      []int
+	messages         []string
+
+	millisecondResetThreshold *int64
+
+	// transient errors
+	serviceUnavailableMaxRetries int
+
+	// oscillation guard
+	oscillationThreshold  int
+	oscillationMultiplier float64
+	oscillationMaxSleep   time.Duration
+
+	// fair release
+	fairReleaseSpacing time.Duration
+
+	// probing
+	probeInterval      time.Duration
+	probeBackoffFactor float64
+
+	// self-regulation
+	restPointWindow   bool
+	requestsPerMinute int
+	throttleKey       ThrottleKey
+	coalescing        bool
+	concurrencyLimit  int
+
+	// testing
+	deterministicOrdering bool
 
 	// callbacks
-	onLimitDetected       OnLimitDetected
-	onSingleLimitExceeded OnSingleLimitExceeded
-	onTotalLimitExceeded  OnTotalLimitExceeded
+	onLimitDetected        OnLimitDetected
+	onSingleLimitExceeded  OnSingleLimitExceeded
+	onTotalLimitExceeded   OnTotalLimitExceeded
+	onWindowBudgetExceeded OnWindowBudgetExceeded
+	onRecovered            OnRecovered
+	onSlipped              OnSlipped
+	onStateChange          OnSecondaryStateChange
+	onServiceUnavailable   OnServiceUnavailable
+	responseInspector      ResponseInspector
+	responseModifier       ResponseModifier
+	preSendCallback        PreSendCallback
+	retryDecider           RetryDecider
+	requestFilter          RequestFilter
+
+	// batched events
+	batchInterval time.Duration
+	batchMax      int
+	batchHandler  LimitEventsHandler
+
+	// debugging
+	sessionRecorder *SessionRecorder
+	debugWriter     *debugWriter
 }
 
 // newConfig creates a new config with the given options.
@@ -35,6 +98,25 @@ func (c *SecondaryRateLimitConfig) ApplyOptions(opts ...Option) {
 	}
 }
 
+// Validate checks that the options do not contradict each other, rather than silently letting one of them win.
+// It is called by the constructors (and for each request with a per-request config, see WithOverrideConfig),
+// and returns an error that wraps ErrConflictingOptions.
+func (c *SecondaryRateLimitConfig) Validate() error {
+	if c.limitAsError && c.probeInterval > 0 {
+		return fmt.Errorf("%w: WithSecondaryLimitAsError never waits for the limit, so there is nothing to probe (WithProbeRetry)", ErrConflictingOptions)
+	}
+	if c.limitAsError && c.coalescing {
+		return fmt.Errorf("%w: WithSecondaryLimitAsError never waits for the limit, so there are no waiting requests to coalesce (WithCoalescing)", ErrConflictingOptions)
+	}
+	if c.limitAsError && c.fairReleaseSpacing > 0 {
+		return fmt.Errorf("%w: WithSecondaryLimitAsError never waits for the limit, so there are no waiting requests to release (WithFairRelease)", ErrConflictingOptions)
+	}
+	if c.singleSleepLimit != nil && *c.singleSleepLimit > 0 && c.minSleep > *c.singleSleepLimit {
+		return fmt.Errorf("%w: WithMinSleep(%v) is above WithSingleSleepLimit(%v), so no limit would ever be waited for", ErrConflictingOptions, c.minSleep, *c.singleSleepLimit)
+	}
+	return nil
+}
+
 // IsAboveSingleSleepLimit returns true if the single sleep duration is above the limit.
 func (c *SecondaryRateLimitConfig) IsAboveSingleSleepLimit(sleepTime time.Duration) bool {
 	return c.singleSleepLimit != nil && sleepTime > *c.singleSleepLimit
@@ -45,6 +127,104 @@ func (c *SecondaryRateLimitConfig) IsAboveTotalSleepLimit(sleepTime time.Duratio
 	return c.totalSleepLimit != nil && totalSleepTime+sleepTime > *c.totalSleepLimit
 }
 
+// isDetectionNeeded returns false if a detected secondary rate limit would have no effect,
+// i.e., sleeping is disabled and there is no callback or other observer (e.g., a debug writer) to notify.
+// in that case, the detection (which buffers the response body) can be skipped altogether.
+func (c *SecondaryRateLimitConfig) isDetectionNeeded() bool {
+	return c.singleSleepLimit == nil || *c.singleSleepLimit > 0 || c.onSingleLimitExceeded != nil || c.limitAsError ||
+		c.onSlipped != nil || c.onStateChange != nil || c.batchHandler != nil ||
+		c.debugWriter != nil || c.sessionRecorder != nil
+}
+
+// defaultSafeRetryMethods are the idempotent methods that are retried by default (see WithSafeRetryMethods).
+var defaultSafeRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// shouldRetry returns false if the request method is not safe to retry,
+// or if the retry decider vetoes retrying the request.
+func (c *SecondaryRateLimitConfig) shouldRetry(request *http.Request, resp *http.Response) bool {
+	return c.isSafeRetryMethod(request.Method) && (c.retryDecider == nil || c.retryDecider(request, resp))
+}
+
+// isSafeRetryMethod returns true if requests with the method may be retried (see WithSafeRetryMethods).
+func (c *SecondaryRateLimitConfig) isSafeRetryMethod(method string) bool {
+	if method == "" {
+		// per net/http, an empty method means GET
+		method = http.MethodGet
+	}
+
+	methods := c.safeRetryMethods
+	if methods == nil {
+		methods = defaultSafeRetryMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequestFiltered returns true if the request is excluded from the detection by the request filter.
+func (c *SecondaryRateLimitConfig) isRequestFiltered(request *http.Request) bool {
+	return c.requestFilter != nil && !c.requestFilter(request)
+}
+
+// recordDecision records the decision regarding the response, if a session recorder is set.
+func (c *SecondaryRateLimitConfig) recordDecision(request *http.Request, resp *http.Response, resetTime *time.Time, decision Decision, waited time.Duration) {
+	if c.debugWriter != nil {
+		c.debugWriter.write(request, resp, resetTime, decision, waited)
+	}
+	if c.sessionRecorder != nil {
+		c.sessionRecorder.record(request, resp, resetTime, decision, waited)
+	}
+}
+
+// applyMinSleep postpones the end of the secondary rate limit so that the sleep is at least the minimal sleep.
+func (c *SecondaryRateLimitConfig) applyMinSleep(secondaryLimit time.Time) time.Time {
+	if minLimit := time.Now().Add(c.minSleep); secondaryLimit.Before(minLimit) {
+		return minLimit
+	}
+	return secondaryLimit
+}
+
+// getMillisecondResetThreshold returns the x-ratelimit-reset value above which it is interpreted as milliseconds
+// (see WithMillisecondResetThreshold).
+func (c *SecondaryRateLimitConfig) getMillisecondResetThreshold() int64 {
+	if c.millisecondResetThreshold != nil {
+		return *c.millisecondResetThreshold
+	}
+	return DefaultMillisecondResetThreshold
+}
+
+// applyMaxResetHorizon clamps the end of the secondary rate limit to the max reset horizon (see WithMaxResetHorizon).
+func (c *SecondaryRateLimitConfig) applyMaxResetHorizon(secondaryLimit time.Time) time.Time {
+	horizon := DefaultMaxResetHorizon
+	if c.maxResetHorizon != nil {
+		horizon = *c.maxResetHorizon
+	}
+	if horizon <= 0 {
+		return secondaryLimit
+	}
+
+	if maxLimit := time.Now().Add(horizon); secondaryLimit.After(maxLimit) {
+		return maxLimit
+	}
+	return secondaryLimit
+}
+
+// smoothSleepTime rounds up the sleep duration to whole seconds, unless precise sleep is set (see WithPreciseSleep).
+func (c *SecondaryRateLimitConfig) smoothSleepTime(sleepTime time.Duration) time.Duration {
+	if c.preciseSleep {
+		return sleepTime
+	}
+	return smoothSleepTime(sleepTime)
+}
+
+// IsAboveGlobalMaxRetries returns true if the number of retries (across all requests) is above the limit.
+func (c *SecondaryRateLimitConfig) IsAboveGlobalMaxRetries(retries int64) bool {
+	return c.globalMaxRetries != nil && retries > *c.globalMaxRetries
+}
+
 type secondaryRateLimitConfigOverridesKey struct{}
 
 // WithOverrideConfig adds config overrides to the context.
@@ -62,3 +242,33 @@ func GetConfigOverrides(ctx context.Context) []Option {
 	}
 	return cfg.([]Option)
 }
+
+type statusCodesOverrideKey struct{}
+
+// WithOverrideStatusCodes overrides the detection status codes (see WithDetectionStatusCodes) for the requests
+// issued with the context, e.g., to treat a 503 as a secondary rate limit for a specific call only.
+func WithOverrideStatusCodes(ctx context.Context, statusCodes ...int) context.Context {
+	return context.WithValue(ctx, statusCodesOverrideKey{}, statusCodes)
+}
+
+// GetOverrideStatusCodes returns the detection status codes override from the context, if any.
+func GetOverrideStatusCodes(ctx context.Context) ([]int, bool) {
+	statusCodes, ok := ctx.Value(statusCodesOverrideKey{}).([]int)
+	return statusCodes, ok
+}
+
+type secondaryRateLimitConfigReplacementKey struct{}
+
+// WithReplaceConfig adds a config replacement to the context.
+// Unlike WithOverrideConfig, nothing is inherited from the existing config:
+// the request uses a fresh config with the given options only (e.g., a completely different set of callbacks).
+// Overrides (see WithOverrideConfig) are applied on top of the replacement.
+func WithReplaceConfig(ctx context.Context, opts ...Option) context.Context {
+	return context.WithValue(ctx, secondaryRateLimitConfigReplacementKey{}, opts)
+}
+
+// GetConfigReplacement returns the config replacement from the context, if any.
+func GetConfigReplacement(ctx context.Context) ([]Option, bool) {
+	opts, ok := ctx.Value(secondaryRateLimitConfigReplacementKey{}).([]Option)
+	return opts, ok
+}
@@ -0,0 +1,50 @@
+package github_ratelimit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DebugEntry is a single line written by the debug writer (see WithDebugWriter).
+type DebugEntry struct {
+	Time       time.Time     `json:"time"`
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	StatusCode int           `json:"status_code"`
+	Decision   Decision      `json:"decision"`
+	ResetTime  *time.Time    `json:"reset_time,omitempty"`
+	Waited     time.Duration `json:"waited"`
+}
+
+// debugWriter writes a JSON line per decision of the waiter.
+// the writes are serialized, so that concurrent requests never interleave lines.
+type debugWriter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+func newDebugWriter(w io.Writer) *debugWriter {
+	return &debugWriter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// write writes the entry for the response. errors are ignored (debugging must not fail requests).
+func (d *debugWriter) write(request *http.Request, resp *http.Response, resetTime *time.Time, decision Decision, waited time.Duration) {
+	entry := DebugEntry{
+		Time:       time.Now(),
+		Method:     request.Method,
+		URL:        request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Decision:   decision,
+		ResetTime:  resetTime,
+		Waited:     waited,
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_ = d.encoder.Encode(entry)
+}
@@ -0,0 +1,75 @@
+package github_ratelimit
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// LimitDecision records what the waiter parsed and decided for a request (see GetLimitDecision).
+// The parsed values are taken from the last response that was detected as a secondary rate limit (if any).
+type LimitDecision struct {
+	// Decision is the decision regarding the returned response.
+	Decision Decision
+	// Limited is true if any attempt of the request was detected as a secondary rate limit.
+	Limited bool
+	// RetryAfter is the reset time parsed from the retry-after header.
+	RetryAfter *time.Time
+	// RateLimitReset is the reset time parsed from the x-ratelimit-reset header.
+	RateLimitReset *time.Time
+	// ResetTime is the reset time used by the waiter.
+	ResetTime *time.Time
+	// Header is the header that ResetTime was taken from (empty if none).
+	Header string
+	// Resource is the rate limit resource that the limit applies to, per the x-ratelimit-resource header (e.g., graphql).
+	Resource string
+	// Retries is the number of times the request was retried.
+	Retries int
+	// Waited is the total time the request waited before being issued (across all attempts).
+	Waited time.Duration
+
+	// serviceUnavailableRetries is the number of retries after a service unavailability (see WithServiceUnavailableBackoff).
+	serviceUnavailableRetries int
+}
+
+// limited records the values parsed from a secondary rate limit response.
+func (d *LimitDecision) limited(resp *http.Response, resetTime time.Time, millisecondThreshold int64) {
+	d.Limited = true
+	d.RetryAfter = parseRetryAfter(resp)
+	d.RateLimitReset = parseXRateLimitReset(resp, millisecondThreshold)
+	d.ResetTime = &resetTime
+	d.Resource = resp.Header.Get(HeaderXRateLimitResource)
+
+	// retry-after is relative to the time it is parsed, so compare against the absolute header
+	switch {
+	case d.RateLimitReset != nil && d.RateLimitReset.Equal(resetTime):
+		d.Header = HeaderXRateLimitReset
+	case d.RetryAfter != nil:
+		d.Header = HeaderRetryAfter
+	default:
+		d.Header = ""
+	}
+}
+
+type limitDecisionKey struct{}
+
+// attachLimitDecision attaches the decision to the context of the response's request.
+func attachLimitDecision(request *http.Request, resp *http.Response, decision *LimitDecision) {
+	if resp == nil {
+		return
+	}
+	if resp.Request != nil {
+		request = resp.Request
+	}
+	resp.Request = request.WithContext(context.WithValue(request.Context(), limitDecisionKey{}, decision))
+}
+
+// GetLimitDecision returns the decision of the waiter regarding the response, or nil if none is attached.
+// The decision is attached to the context of the response's request (resp.Request).
+func GetLimitDecision(resp *http.Response) *LimitDecision {
+	if resp == nil || resp.Request == nil {
+		return nil
+	}
+	decision, _ := resp.Request.Context().Value(limitDecisionKey{}).(*LimitDecision)
+	return decision
+}
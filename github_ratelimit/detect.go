@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type SecondaryRateLimitBody struct {
@@ -19,32 +24,187 @@ const (
 )
 
 // IsSecondaryRateLimit checks whether the response is a legitimate secondary rate limit.
-// It checks the prefix of the message and the suffix of the documentation URL in the response body in case
-// the message or documentation URL is modified in the future.
+// It checks the prefix of the message and the suffix of the documentation URL in the response body
+// in case the message or documentation URL is modified in the future (or localized).
 // https://docs.github.com/en/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits
 func (s SecondaryRateLimitBody) IsSecondaryRateLimit() bool {
-	return strings.HasPrefix(s.Message, SecondaryRateLimitMessage) ||
-		strings.HasSuffix(s.DocumentURL, SecondaryRateLimitDocumentationPathSuffix)
+	return s.isSecondaryRateLimit(nil)
+}
+
+// isSecondaryRateLimit is IsSecondaryRateLimit with additional message prefixes (see WithSecondaryRateLimitMessages).
+func (s SecondaryRateLimitBody) isSecondaryRateLimit(messages []string) bool {
+	return isSecondaryRateLimitMessage(s.Message, messages) ||
+		isSecondaryRateLimitDocumentURL(s.DocumentURL)
+}
+
+// isSecondaryRateLimitMessage checks whether the message starts with the default prefix or any of the given ones.
+func isSecondaryRateLimitMessage(message string, messages []string) bool {
+	if strings.HasPrefix(message, SecondaryRateLimitMessage) {
+		return true
+	}
+	for _, prefix := range messages {
+		if prefix != "" && strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecondaryRateLimitDocumentURL checks whether the documentation URL points to the secondary rate limits section.
+// only the section anchor (or the last path segment, if there is no anchor) is considered,
+// so that other errors (e.g., permission errors) pointing to unrelated documentation are never treated as a limit.
+func isSecondaryRateLimitDocumentURL(documentURL string) bool {
+	u, err := url.Parse(documentURL)
+	if err != nil {
+		return false
+	}
+
+	section := u.Fragment
+	if section == "" {
+		section = path.Base(u.Path)
+	}
+
+	return strings.HasSuffix(section, SecondaryRateLimitDocumentationPathSuffix)
+}
+
+// DetectSecondaryLimit checks whether the response is a legitimate secondary rate limit,
+// and if so, parses the time at which the limit is expected to end.
+// The returned time is nil if the response is not a secondary rate limit,
+// or if it is one but carries no usable reset header.
+// It allows reusing the detection logic with custom http.RoundTripper implementations.
+// Note: the response body is read and restored (see isSecondaryRateLimit).
+// The request of the response (if set) tells the rate limit resource of the request (see isPrimaryRateLimit).
+func DetectSecondaryLimit(resp *http.Response) (*time.Time, bool) {
+	if !isSecondaryRateLimit(resp.Request, resp, nil, nil) {
+		return nil, false
+	}
+	return parseSecondaryLimitTime(resp, ResetPreferenceRetryAfterFirst, DefaultMillisecondResetThreshold), true
+}
+
+// detection is the outcome of the secondary rate limit detection of a response (see detectSecondaryLimit).
+type detection int
+
+const (
+	// detectionSkipped means the detection did not run (it is not needed, or the request is filtered out).
+	detectionSkipped detection = iota
+	// detectionPassed means the response is not a secondary rate limit.
+	detectionPassed
+	// detectionLimited means the response is a secondary rate limit (even if it carries no usable reset header).
+	detectionLimited
+)
+
+// detectSecondaryLimit returns the end time of the secondary rate limit carried by the response, if any,
+// along with the outcome of the detection, since a limit may be detected without a usable reset time.
+// the detection is skipped when it is not needed or the request is filtered out by the config.
+func (t *SecondaryRateLimitWaiter) detectSecondaryLimit(config *SecondaryRateLimitConfig, request *http.Request, resp *http.Response) (*time.Time, detection) {
+	if !(config.isDetectionNeeded() || t.eventsEnabled.Load()) || config.isRequestFiltered(request) {
+		return nil, detectionSkipped
+	}
+	if !(config.lenientDetection && isLenientSecondaryRateLimit(request, resp, config.statusCodes)) && !isSecondaryRateLimit(request, resp, config.statusCodes, config.messages) {
+		return nil, detectionPassed
+	}
+	return parseSecondaryLimitTime(resp, config.resetPreference, config.getMillisecondResetThreshold()), detectionLimited
+}
+
+// isLenientSecondaryRateLimit checks whether the response is a rate limit status with a retry-after header,
+// regardless of the body (see WithLenientDetection).
+func isLenientSecondaryRateLimit(request *http.Request, resp *http.Response, statusCodes []int) bool {
+	if !isRateLimitStatus(resp.StatusCode, statusCodes) || resp.Header == nil {
+		return false
+	}
+	readTrailers(resp)
+
+	// a primary rate limit
+	if isPrimaryRateLimit(request, resp) {
+		return false
+	}
+
+	return parseRetryAfter(resp) != nil
+}
+
+// isPrimaryRateLimit checks whether the response reports an exhausted primary rate limit quota.
+// the quota belongs to the resource in x-ratelimit-resource, so an exhausted quota of a resource
+// other than the one of the request (if known, see requestResource) does not make the response a primary rate limit.
+func isPrimaryRateLimit(request *http.Request, resp *http.Response) bool {
+	remaining, ok := httpHeaderIntValue(resp.Header, HeaderXRateLimitRemaining)
+	if !ok || remaining != 0 {
+		return false
+	}
+
+	resource := resp.Header.Get(HeaderXRateLimitResource)
+	expected := requestResource(request)
+	return resource == "" || expected == "" || resource == expected
+}
+
+// requestResource returns the rate limit resource of the request, as reported in x-ratelimit-resource,
+// or an empty string if it cannot be told by the path (e.g., most REST endpoints).
+// see https://docs.github.com/en/rest/rate-limit/rate-limit#get-rate-limit-status-for-the-authenticated-user
+func requestResource(request *http.Request) string {
+	if request == nil || request.URL == nil {
+		return ""
+	}
+
+	// GitHub Enterprise Server serves the API under /api (e.g., /api/v3/search/issues and /api/graphql)
+	p := strings.TrimPrefix(request.URL.Path, "/api")
+	p = strings.TrimPrefix(p, "/v3")
+	switch {
+	case p == "/graphql":
+		return "graphql"
+	case p == "/search/code":
+		return "code_search"
+	case strings.HasPrefix(p, "/search/"):
+		return "search"
+	default:
+		return ""
+	}
 }
 
 // isRateLimitStatus checks whether the status code is a rate limit status code.
+// the given status codes replace the default ones, unless empty (see WithDetectionStatusCodes).
 // see https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api#exceeding-the-rate-limit
-func isRateLimitStatus(statusCode int) bool {
-	return statusCode == http.StatusForbidden || statusCode == http.StatusTooManyRequests
+func isRateLimitStatus(statusCode int, statusCodes []int) bool {
+	if len(statusCodes) == 0 {
+		return statusCode == http.StatusForbidden || statusCode == http.StatusTooManyRequests
+	}
+	for _, code := range statusCodes {
+		if statusCode == code {
+			return true
+		}
+	}
+	return false
 }
 
 // isSecondaryRateLimit checks whether the response is a legitimate secondary rate limit.
-func isSecondaryRateLimit(resp *http.Response) bool {
-	if !isRateLimitStatus(resp.StatusCode) {
+// the given messages are accepted in addition to the default one (see WithSecondaryRateLimitMessages).
+func isSecondaryRateLimit(request *http.Request, resp *http.Response, statusCodes []int, messages []string) bool {
+	if !isRateLimitStatus(resp.StatusCode, statusCodes) {
 		return false
 	}
 
 	if resp.Header == nil {
 		return false
 	}
+	readTrailers(resp)
 
-	// a primary rate limit
-	if remaining, ok := httpHeaderIntValue(resp.Header, HeaderXRateLimitRemaining); ok && remaining == 0 {
+	// a primary rate limit (of the resource in x-ratelimit-resource, e.g., graphql).
+	// a remaining quota means the resource is not exhausted, so the response may still be a secondary rate limit.
+	if isPrimaryRateLimit(request, resp) {
+		return false
+	}
+
+	// a 429 with a retry-after header is unambiguous, regardless of the body
+	if resp.StatusCode == http.StatusTooManyRequests && parseRetryAfter(resp) != nil {
+		return true
+	}
+
+	// a non-JSON body (e.g., binary or huge) - rely solely on the retry-after header.
+	// x-ratelimit-reset alone is not enough, since it is set on most responses (as the reset of the primary rate limit).
+	if !isJSONContentType(resp.Header) {
+		return parseRetryAfter(resp) != nil
+	}
+
+	// no body to validate (e.g., returned by some mocks and transports)
+	if resp.Body == nil {
 		return false
 	}
 
@@ -56,15 +216,55 @@ func isSecondaryRateLimit(resp *http.Response) bool {
 	}
 
 	// restore original body
-	resp.Body = io.NopCloser(bytes.NewReader(rawBody))
+	restoreBody(resp, rawBody)
 
 	var body SecondaryRateLimitBody
 	if err := json.Unmarshal(rawBody, &body); err != nil {
 		return false // unexpected error
 	}
-	if !body.IsSecondaryRateLimit() {
+	if !body.isSecondaryRateLimit(messages) {
 		return false
 	}
 
 	return true
 }
+
+// readTrailers buffers the body of a response that announces trailers,
+// since the trailer values are only populated once the body is read to the end.
+func readTrailers(resp *http.Response) {
+	for _, values := range resp.Trailer {
+		if values == nil {
+			bufferBody(resp)
+			return
+		}
+	}
+}
+
+// isJSONContentType checks whether the response body is expected to be JSON,
+// i.e., the content type is either absent or a JSON media type.
+func isJSONContentType(header http.Header) bool {
+	contentType := header.Get(HeaderContentType)
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// restoreBody replaces the (consumed) response body with the given raw body.
+// the length fields are updated to match the new body,
+// so that downstream consumers (e.g., JSON decoders) do not mis-read it.
+// the new body is byte-for-byte identical to the original one, and closing it is a no-op
+// (the original body is closed exactly once, by the code that consumed it).
+func restoreBody(resp *http.Response, rawBody []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(rawBody))
+	resp.ContentLength = int64(len(rawBody))
+	if resp.Header.Get(HeaderContentLength) != "" {
+		resp.Header.Set(HeaderContentLength, strconv.Itoa(len(rawBody)))
+	}
+}
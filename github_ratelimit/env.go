@@ -0,0 +1,78 @@
+package github_ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// environment variables read by OptionsFromEnv.
+const (
+	EnvSingleSleepLimit = "GH_RL_SINGLE_SLEEP_LIMIT"
+	EnvTotalSleepLimit  = "GH_RL_TOTAL_SLEEP_LIMIT"
+	EnvMaxRetries       = "GH_RL_MAX_RETRIES"
+)
+
+// OptionsFromEnv returns the options that correspond to the set environment variables:
+//   - GH_RL_SINGLE_SLEEP_LIMIT: the limit of WithSingleSleepLimit (a duration, parsed by time.ParseDuration).
+//   - GH_RL_TOTAL_SLEEP_LIMIT: the limit of WithTotalSleepLimit (a duration, parsed by time.ParseDuration).
+//   - GH_RL_MAX_RETRIES: WithGlobalMaxRetries (an integer), i.e., the number of retries across all requests
+//     for the lifetime of the waiter (not per request).
+//
+// Unset (or empty) variables are ignored, so the result can be appended to options set in code
+// (the last option wins). Only the limits are set, so the callbacks set in code are kept.
+// Returns an error if a variable cannot be parsed.
+func OptionsFromEnv() ([]Option, error) {
+	var opts []Option
+
+	if limit, ok, err := durationFromEnv(EnvSingleSleepLimit); err != nil {
+		return nil, err
+	} else if ok {
+		opts = append(opts, withSingleSleepLimitDuration(limit))
+	}
+
+	if limit, ok, err := durationFromEnv(EnvTotalSleepLimit); err != nil {
+		return nil, err
+	} else if ok {
+		opts = append(opts, withTotalSleepLimitDuration(limit))
+	}
+
+	if value := os.Getenv(EnvMaxRetries); value != "" {
+		retries, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("github_ratelimit: invalid %s: %w", EnvMaxRetries, err)
+		}
+		opts = append(opts, WithGlobalMaxRetries(retries))
+	}
+
+	return opts, nil
+}
+
+// withSingleSleepLimitDuration sets the limit of WithSingleSleepLimit, keeping the callback (if any) as is.
+func withSingleSleepLimitDuration(limit time.Duration) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.singleSleepLimit = &limit
+	}
+}
+
+// withTotalSleepLimitDuration sets the limit of WithTotalSleepLimit, keeping the callback (if any) as is.
+func withTotalSleepLimitDuration(limit time.Duration) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.totalSleepLimit = &limit
+	}
+}
+
+// durationFromEnv parses the duration in the given environment variable.
+// returns false if the variable is unset or empty.
+func durationFromEnv(name string) (time.Duration, bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("github_ratelimit: invalid %s: %w", name, err)
+	}
+	return d, true, nil
+}
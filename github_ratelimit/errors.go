@@ -0,0 +1,66 @@
+package github_ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrGlobalMaxRetriesExceeded is returned when a secondary rate limit is detected
+// after the process-wide retry cap (see WithGlobalMaxRetries) has been exhausted.
+var ErrGlobalMaxRetriesExceeded = errors.New("github_ratelimit: global max retries for secondary rate limits exceeded")
+
+// ErrOperationBudgetExceeded is returned when a wait (e.g., a secondary rate limit sleep)
+// would exceed the remaining wait budget of the operation (see WithOperationBudget).
+var ErrOperationBudgetExceeded = errors.New("github_ratelimit: operation wait budget exceeded")
+
+// ErrConflictingOptions is returned by the constructors when the options contradict each other (see Validate).
+var ErrConflictingOptions = errors.New("github_ratelimit: conflicting options")
+
+// ErrSessionExhausted is returned by a SessionReplayer once all the recorded responses are replayed.
+var ErrSessionExhausted = errors.New("github_ratelimit: recorded session exhausted")
+
+// WaitCanceledError is returned when the context (of the request, or the root context) is done during a sleep (or a Pause).
+// It wraps the context error, i.e., errors.Is(err, context.Canceled) and errors.Is(err, context.DeadlineExceeded) hold.
+type WaitCanceledError struct {
+	// Elapsed is the duration of the sleep until it was canceled.
+	Elapsed time.Duration
+	// Remaining is the remaining duration of the sleep (zero for a Pause, whose end is unknown).
+	Remaining time.Duration
+	Err       error
+}
+
+func newWaitCanceledError(err error, start time.Time, sleepDuration time.Duration) *WaitCanceledError {
+	elapsed := time.Since(start)
+	remaining := sleepDuration - elapsed
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &WaitCanceledError{
+		Elapsed:   elapsed,
+		Remaining: remaining,
+		Err:       err,
+	}
+}
+
+func (e *WaitCanceledError) Error() string {
+	return fmt.Sprintf("github_ratelimit: wait canceled after %v (%v remaining): %v", e.Elapsed, e.Remaining, e.Err)
+}
+
+func (e *WaitCanceledError) Unwrap() error {
+	return e.Err
+}
+
+// SecondaryRateLimitError is returned (instead of sleeping) when a secondary rate limit is detected
+// and WithSecondaryLimitAsError is set. The response body is buffered (and the connection is released),
+// so the caller may still consume it, but does not have to close it.
+type SecondaryRateLimitError struct {
+	ResetTime time.Time
+	Request   *http.Request
+	Response  *http.Response
+}
+
+func (e *SecondaryRateLimitError) Error() string {
+	return fmt.Sprintf("github_ratelimit: secondary rate limit reached (until %v)", e.ResetTime)
+}
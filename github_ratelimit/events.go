@@ -0,0 +1,84 @@
+package github_ratelimit
+
+import (
+	"time"
+)
+
+// limitEventsBufferSize is the number of undelivered events kept before dropping the oldest.
+const limitEventsBufferSize = 16
+
+// LimitEvent signals a change in the state of the secondary rate limit.
+// Active is true when a new limit starts (until SleepUntil), and false when the limit clears.
+type LimitEvent struct {
+	Active     bool
+	SleepUntil time.Time
+}
+
+// LimitEvents returns a channel that signals when a secondary rate limit becomes active and when it clears,
+// e.g., to let a worker pool pause/resume enqueuing work.
+// The channel is buffered and drops the oldest events, so that the waiter never blocks on it.
+func (t *SecondaryRateLimitWaiter) LimitEvents() <-chan LimitEvent {
+	t.eventsEnabled.Store(true)
+	return t.events
+}
+
+// emitEvent delivers the event, dropping the oldest undelivered event if the buffer is full.
+func (t *SecondaryRateLimitWaiter) emitEvent(event LimitEvent) {
+	if t.batcher != nil {
+		t.batcher.add(event)
+	}
+	if !t.eventsEnabled.Load() {
+		return
+	}
+
+	for {
+		select {
+		case t.events <- event:
+			return
+		default:
+			// drop the oldest event to make room
+			select {
+			case <-t.events:
+			default:
+			}
+		}
+	}
+}
+
+// notifyStateChange triggers the state change callback of the config with the event (if any).
+// Note: expects the lock to be released, so that the callback may use the waiter.
+func notifyStateChange(config *SecondaryRateLimitConfig, event *LimitEvent) {
+	if event == nil || config.onStateChange == nil {
+		return
+	}
+	config.onStateChange(event.Active, event.SleepUntil)
+}
+
+// emitLimitActiveUnlocked signals the new active limit, and schedules the signal for its clearance.
+// the config is the one of the request that activated the limit (see WithSecondaryStateChangeCallback).
+// returns the event to notify the state change callback with, once the lock is released (see notifyStateChange).
+// Note: expects the lock to be held.
+func (t *SecondaryRateLimitWaiter) emitLimitActiveUnlocked(config *SecondaryRateLimitConfig, sleepUntil *time.Time) *LimitEvent {
+	if !t.eventsEnabled.Load() && t.batcher == nil && config.onStateChange == nil {
+		return nil
+	}
+
+	event := LimitEvent{Active: true, SleepUntil: *sleepUntil}
+	t.emitEvent(event)
+
+	time.AfterFunc(time.Until(*sleepUntil), func() {
+		t.lock.RLock()
+		// the limit was either released early or replaced by a newer one
+		current := t.sleepUntil == sleepUntil
+		if current {
+			t.emitEvent(LimitEvent{Active: false, SleepUntil: *sleepUntil})
+		}
+		t.lock.RUnlock()
+
+		if current {
+			notifyStateChange(config, &LimitEvent{Active: false, SleepUntil: *sleepUntil})
+		}
+	})
+
+	return &event
+}
@@ -0,0 +1,44 @@
+package github_ratelimit
+
+import (
+	"time"
+)
+
+// fairQueue assigns the release slots of the requests that wait for a secondary rate limit (see WithFairRelease).
+type fairQueue struct {
+	limit *time.Time
+	next  int
+}
+
+// isFairQueuePendingUnlocked returns true if a release slot of the last rate limit is yet to come,
+// i.e., a new request must queue up behind the waiting ones even if the limit itself is over.
+// Note: expects the lock to be held.
+func (t *SecondaryRateLimitWaiter) isFairQueuePendingUnlocked(spacing time.Duration) bool {
+	if t.sleepUntil == nil || t.fair.limit != t.sleepUntil {
+		return false
+	}
+	return time.Now().Before(t.sleepUntil.Add(time.Duration(t.fair.next) * spacing))
+}
+
+// claimFairSlot claims the next release slot of the active rate limit, in arrival order.
+// returns the duration to wait for the slot, and the rate limit that the slot belongs to.
+func (t *SecondaryRateLimitWaiter) claimFairSlot(spacing time.Duration) (time.Duration, *time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.sleepUntil == nil {
+		return 0, nil
+	}
+	if t.fair.limit != t.sleepUntil {
+		t.fair = fairQueue{limit: t.sleepUntil}
+	}
+
+	releaseAt := t.sleepUntil.Add(time.Duration(t.fair.next) * spacing)
+	if !releaseAt.After(time.Now()) {
+		// the queue is drained
+		return 0, t.sleepUntil
+	}
+
+	t.fair.next++
+	return time.Until(releaseAt), t.sleepUntil
+}
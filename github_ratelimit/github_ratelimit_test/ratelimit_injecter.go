@@ -38,8 +38,12 @@ type SecondaryRateLimitInjecterOptions struct {
 	InvalidBody         bool
 	UseXRateLimit       bool
 	UsePrimaryRateLimit bool
+	UseBothRateLimits   bool
 	DocumentationURL    string
 	HttpStatusCode      int
+	// ActualSleep, if set, is the actual block duration, which may differ from the advertised sleep,
+	// i.e., the block is released either before (early) or after (late) the advertised sleep is over.
+	ActualSleep time.Duration
 }
 
 func NewRateLimitInjecter(base http.RoundTripper, options *SecondaryRateLimitInjecterOptions) (http.RoundTripper, error) {
@@ -75,6 +79,7 @@ type SecondaryRateLimitInjecter struct {
 	base          http.RoundTripper
 	options       *SecondaryRateLimitInjecterOptions
 	blockUntil    time.Time
+	releaseAt     time.Time
 	lock          sync.Mutex
 	AbuseAttempts int
 }
@@ -92,10 +97,11 @@ func (t *SecondaryRateLimitInjecter) RoundTrip(request *http.Request) (*http.Res
 	now := time.Now()
 	if t.blockUntil.IsZero() {
 		t.blockUntil = now
+		t.releaseAt = now
 	}
 
 	// on-going rate limit
-	if t.blockUntil.After(now) {
+	if t.releaseAt.After(now) {
 		t.AbuseAttempts++
 		return t.inject(resp)
 	}
@@ -105,6 +111,10 @@ func (t *SecondaryRateLimitInjecter) RoundTrip(request *http.Request) (*http.Res
 	// start a rate limit period
 	if !now.Before(nextStart) {
 		t.blockUntil = nextStart.Add(t.options.Sleep)
+		t.releaseAt = t.blockUntil
+		if t.options.ActualSleep > 0 {
+			t.releaseAt = nextStart.Add(t.options.ActualSleep)
+		}
 		return t.inject(resp)
 	}
 
@@ -157,7 +167,9 @@ func (t *SecondaryRateLimitInjecter) inject(resp *http.Response) (*http.Response
 
 		resp.StatusCode = getHttpStatusCode(t.options.HttpStatusCode)
 		resp.Body = body
-		if t.options.UseXRateLimit {
+		if t.options.UseBothRateLimits {
+			return t.toPrimaryRateLimitResponse(resp), nil
+		} else if t.options.UseXRateLimit {
 			return t.toXRateLimitResponse(resp), nil
 		} else {
 			return t.toRetryResponse(resp), nil
@@ -184,6 +196,10 @@ func (t *SecondaryRateLimitInjecter) toPrimaryRateLimitResponse(resp *http.Respo
 
 func (t *SecondaryRateLimitInjecter) getTimeToBlock() time.Duration {
 	timeUntil := time.Until(t.blockUntil)
+	if timeUntil <= 0 {
+		// a late release - advertise the remaining block
+		timeUntil = time.Until(t.releaseAt)
+	}
 	if timeUntil.Nanoseconds()%int64(time.Second) > 0 {
 		timeUntil += time.Second
 	}
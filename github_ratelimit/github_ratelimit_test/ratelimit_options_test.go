@@ -0,0 +1,1475 @@
+package github_ratelimit_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofri/go-github-ratelimit/github_ratelimit"
+)
+
+func TestResponseInspector(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	var inspected atomic.Int64
+	inspector := func(req *http.Request, resp *http.Response) {
+		if req == nil || resp == nil {
+			t.Errorf("missing request / response: %v / %v", req, resp)
+		}
+		inspected.Add(1)
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithResponseInspector(inspector))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	if got, want := inspected.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+
+	// attempt during rate limit - inspected once for the limit and once for the retry
+	waitForNextSleep(i)
+	_, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inspected.Load(), int64(3); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestRetryDecider(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	decider := func(req *http.Request, resp *http.Response) bool {
+		return req.URL.Path != "/no-retry"
+	}
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithRetryDecider(decider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// vetoed during rate limit - returned without a retry
+	tBefore := time.Now()
+	resp, err := c.Get("/no-retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, limit := time.Since(tBefore), sleep/2; got >= limit {
+		t.Fatal(got, limit)
+	}
+	decision := github_ratelimit.GetLimitDecision(resp)
+	if got, want := decision.Decision, github_ratelimit.DecisionReturn; got != want {
+		t.Fatal(got, want)
+	}
+
+	// not vetoed during rate limit - retried
+	waitForNextSleep(i)
+	resp, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestSafeRetryMethods(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	post := func(c *http.Client) *github_ratelimit.LimitDecision {
+		resp, err := c.Post("/", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return github_ratelimit.GetLimitDecision(resp)
+	}
+
+	// by default, a POST during rate limit is not replayed
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+	if got, want := post(c).Decision, github_ratelimit.DecisionReturn; got != want {
+		t.Fatal(got, want)
+	}
+
+	// unless it is configured as safe to retry
+	i = setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err = github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithSafeRetryMethods(http.MethodGet, http.MethodPost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+	if got, want := post(c).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestPreSendCallback(t *testing.T) {
+	t.Parallel()
+	const every = 5 * time.Second
+	const sleep = 1 * time.Second
+
+	var lock sync.Mutex
+	var waits []time.Duration
+	callback := func(req *http.Request, waited time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		waits = append(waits, waited)
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithPreSendCallback(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - the retry waits for the sleep
+	tBefore := time.Now()
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(tBefore)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got, want := len(waits), 3; got != want {
+		t.Fatal(got, want)
+	}
+	if got, min, max := waits[2], sleep/2, elapsed; got < min || got > max {
+		t.Fatalf("unexpected wait: %v < %v <= %v", min, got, max)
+	}
+}
+
+func TestRecoveryCallback(t *testing.T) {
+	t.Parallel()
+	const every = 5 * time.Second
+	const sleep = 1 * time.Second
+
+	var recoveries atomic.Int64
+	var totalWaited atomic.Int64
+	callback := func(cbContext *github_ratelimit.CallbackContext, waited time.Duration) {
+		if cbContext.Request == nil || cbContext.Response == nil {
+			t.Errorf("missing request / response: %v / %v", cbContext.Request, cbContext.Response)
+		}
+		recoveries.Add(1)
+		totalWaited.Store(int64(waited))
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithRecoveryCallback(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing - not a recovery
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - recovered after the sleep
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got, want := recoveries.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := time.Duration(totalWaited.Load()), github_ratelimit.GetLimitDecision(resp).Waited; got != want {
+		t.Fatal(got, want)
+	}
+	if got, min := time.Duration(totalWaited.Load()), sleep/2; got < min {
+		t.Fatal(got, min)
+	}
+}
+
+func TestGlobalMaxRetries(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	r, err := github_ratelimit.NewRateLimitWaiter(i, github_ratelimit.WithGlobalMaxRetries(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+
+	// first limit - retried within the cap
+	waitForNextSleep(i)
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// second limit (on another request) - the cap is exceeded
+	waitForNextSleep(i)
+	if _, err := c.Get("/"); !errors.Is(err, github_ratelimit.ErrGlobalMaxRetriesExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// reset the counter - retried again
+	r.ResetGlobalRetries()
+	waitForNextSleep(i)
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResponseModifier(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderXRateLimitRemaining, "4321")
+	header.Set(github_ratelimit.HeaderXRateLimitReset, "1700000000")
+	header.Set(github_ratelimit.HeaderContentType, "application/json")
+	base := &staticResponder{
+		statusCode: http.StatusOK,
+		header:     header,
+		body:       []byte(`{}`),
+	}
+
+	stripRateLimitHeaders := func(resp *http.Response) {
+		for key := range resp.Header {
+			if strings.HasPrefix(strings.ToLower(key), "x-ratelimit-") {
+				resp.Header.Del(key)
+			}
+		}
+	}
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base, github_ratelimit.WithResponseModifier(stripRateLimitHeaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get(github_ratelimit.HeaderXRateLimitRemaining); got != "" {
+		t.Fatal(got)
+	}
+	if got := resp.Header.Get(github_ratelimit.HeaderXRateLimitReset); got != "" {
+		t.Fatal(got)
+	}
+	if got, want := resp.Header.Get(github_ratelimit.HeaderContentType), "application/json"; got != want {
+		t.Fatal(got, want)
+	}
+	if body, err := io.ReadAll(resp.Body); err != nil || string(body) != `{}` {
+		t.Fatal(string(body), err)
+	}
+}
+
+// readTrackingBody is a response body that records whether it was read.
+type readTrackingBody struct {
+	io.Reader
+	read atomic.Bool
+}
+
+func (b *readTrackingBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.Reader.Read(p)
+}
+
+func (b *readTrackingBody) Close() error {
+	return nil
+}
+
+type readTrackingServer struct {
+	bodies []*readTrackingBody
+	lock   sync.Mutex
+}
+
+func (s *readTrackingServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := &readTrackingBody{Reader: bytes.NewReader(bytes.Repeat([]byte{0}, 1024*1024))}
+	s.lock.Lock()
+	s.bodies = append(s.bodies, body)
+	s.lock.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{},
+		Body:       body,
+	}, nil
+}
+
+func TestRequestFilter(t *testing.T) {
+	t.Parallel()
+
+	server := &readTrackingServer{}
+	filter := func(r *http.Request) bool {
+		return r.URL.Host != "objects.githubusercontent.com"
+	}
+	c, err := github_ratelimit.NewRateLimitWaiterClient(server, github_ratelimit.WithRequestFilter(filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// filtered request - the body is not buffered
+	if _, err := c.Get("https://objects.githubusercontent.com/asset"); err != nil {
+		t.Fatal(err)
+	}
+	// unfiltered request - the body is read by the detection
+	if _, err := c.Get("https://api.github.com/repos"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(server.bodies), 2; got != want {
+		t.Fatal(got, want)
+	}
+	if server.bodies[0].read.Load() {
+		t.Fatalf("filtered response body was read")
+	}
+	if !server.bodies[1].read.Load() {
+		t.Fatalf("unfiltered response body was not read")
+	}
+}
+
+func TestProbeRetry(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 10 * time.Second
+	const release = 1 * time.Second
+
+	// the injecter advertises a long sleep, but releases the block early
+	i := setupInjecterWithOptions(t, SecondaryRateLimitInjecterOptions{
+		Every:       every,
+		Sleep:       sleep,
+		ActualSleep: release,
+	}, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithProbeRetry(release/2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - probes pick up the early release
+	tBefore := time.Now()
+	const parallelReqs = 5
+	errChan := make(chan error, parallelReqs)
+	for index := 0; index < parallelReqs; index++ {
+		go func() {
+			_, err := c.Get("/")
+			errChan <- err
+		}()
+	}
+	for index := 0; index < parallelReqs; index++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, limit := time.Since(tBefore), sleep/2; got >= limit {
+		t.Fatal(got, limit)
+	}
+}
+
+func TestProbeRetryNotReleasedByUndetectedLimit(t *testing.T) {
+	t.Parallel()
+	const probeInterval = 100 * time.Millisecond
+
+	// both probes get a secondary rate limit response, which is not known to be a pass:
+	// the detection of a filtered request is skipped, and a limit without a reset header carries no reset time.
+	for _, path := range []string{"/filtered", "/reset-less"} {
+		path := path
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+			base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				header := http.Header{}
+				if r.URL.Path != "/reset-less" {
+					header.Set(github_ratelimit.HeaderRetryAfter, "10")
+				}
+				return newSecondaryLimitResponse(t, header), nil
+			})
+			r, err := github_ratelimit.NewRateLimitWaiter(base,
+				github_ratelimit.WithProbeRetry(probeInterval, 1),
+				github_ratelimit.WithRequestFilter(func(r *http.Request) bool {
+					return r.URL.Path != "/filtered"
+				}),
+				// return the limited response that activates the limit, instead of sleeping
+				github_ratelimit.WithRetryDecider(func(r *http.Request, _ *http.Response) bool {
+					return r.URL.Path != "/"
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := r.LimitEvents()
+			c := &http.Client{
+				Transport: r,
+			}
+
+			// activate the limit
+			resp, err := c.Get("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if event := <-events; !event.Active {
+				t.Fatal(event)
+			}
+
+			// the request is issued as a probe during the limit
+			tBefore := time.Now()
+			resp, err = c.Get(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := time.Since(tBefore); got < probeInterval {
+				t.Fatal(got, probeInterval)
+			}
+
+			// the limit is still active
+			select {
+			case event := <-events:
+				t.Fatalf("unexpected event: %+v", event)
+			default:
+			}
+		})
+	}
+}
+
+func TestSecondaryLimitAsError(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithSecondaryLimitAsError())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - expect an error instead of a sleep
+	tBefore := time.Now()
+	_, err = c.Get("/")
+	if got, limit := time.Since(tBefore), sleep/2; got >= limit {
+		t.Fatal(got, limit)
+	}
+
+	var limitErr *github_ratelimit.SecondaryRateLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limitErr.Request == nil || limitErr.Response == nil {
+		t.Fatalf("missing request / response: %v / %v", limitErr.Request, limitErr.Response)
+	}
+	defer limitErr.Response.Body.Close()
+	if got, min, max := time.Until(limitErr.ResetTime), time.Duration(0), sleep; got <= min || got > max {
+		t.Fatalf("unexpected reset time: %v < %v <= %v", min, got, max)
+	}
+}
+
+func TestRestPointWindow(t *testing.T) {
+	t.Parallel()
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(&nopServer{}, github_ratelimit.WithRestPointWindow())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Do(req)
+		return err
+	}
+
+	// fill the window with mutating requests
+	const requests = github_ratelimit.RestPointsPerMinute / github_ratelimit.RestWritePoints
+	for index := 0; index < requests; index++ {
+		if err := post(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the window is full - the next request blocks until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/2)
+	defer cancel()
+	if err := post(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestThrottleKey(t *testing.T) {
+	t.Parallel()
+	const keyHeader = "X-Installation"
+
+	throttleKey := func(r *http.Request) string {
+		return r.Header.Get(keyHeader)
+	}
+	c, err := github_ratelimit.NewRateLimitWaiterClient(&nopServer{},
+		github_ratelimit.WithRestPointWindow(),
+		github_ratelimit.WithThrottleKey(throttleKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(ctx context.Context, key string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(keyHeader, key)
+		_, err = c.Do(req)
+		return err
+	}
+
+	// fill the window of the first key
+	const requests = github_ratelimit.RestPointsPerMinute / github_ratelimit.RestWritePoints
+	for index := 0; index < requests; index++ {
+		if err := post(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the window of the second key is independent
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/2)
+	defer cancel()
+	if err := post(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the window of the first key is full
+	if err := post(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRequestsPerMinute(t *testing.T) {
+	t.Parallel()
+	const rpm = 10
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(&nopServer{}, github_ratelimit.WithRequestsPerMinute(rpm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Do(req)
+		return err
+	}
+
+	// the requests within the limit are not paced
+	start := time.Now()
+	for index := 0; index < rpm; index++ {
+		if err := get(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal(elapsed)
+	}
+
+	// the window is full - the next request blocks until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/2)
+	defer cancel()
+	if err := get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPacingRechecksLimit(t *testing.T) {
+	t.Parallel()
+	// the pacing wait lasts until the first request slides out of the window (a minute),
+	// so the limit (detected during the wait) must outlast it.
+	const limitSeconds = 61
+
+	var pacedSentAt atomic.Value
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Path {
+		case "/limit":
+			header := http.Header{}
+			header.Set(github_ratelimit.HeaderRetryAfter, strconv.Itoa(limitSeconds))
+			return newSecondaryLimitResponse(t, header), nil
+		case "/paced":
+			pacedSentAt.Store(time.Now())
+		}
+		return (&nopServer{}).RoundTrip(r)
+	})
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base, github_ratelimit.WithRequestsPerMinute(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fill the window, and pace a request behind it
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := c.Get("/paced")
+		errChan <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// a limit is detected while the request is paced, so it waits for the limit once the pacing is over
+	limitEnd := time.Now().Add(limitSeconds * time.Second)
+	req, err := http.NewRequestWithContext(github_ratelimit.WithBypass(context.Background()), http.MethodGet, "/limit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if sentAt := pacedSentAt.Load().(time.Time); sentAt.Before(limitEnd) {
+		t.Fatal(sentAt, limitEnd)
+	}
+}
+
+func TestMinSleep(t *testing.T) {
+	t.Parallel()
+	const minSleep = 2 * time.Second
+
+	// a single secondary rate limit with a tiny retry-after
+	base := newLimitOnceTransport(t, nil)
+	var requests atomic.Int64
+	counter := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return base.RoundTrip(r)
+	})
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(counter, github_ratelimit.WithMinSleep(minSleep))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tBefore := time.Now()
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, min := time.Since(tBefore), minSleep; got < min {
+		t.Fatal(got, min)
+	}
+	if got, want := requests.Load(), int64(2); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestMaxResetHorizon(t *testing.T) {
+	t.Parallel()
+
+	// a secondary rate limit with an absurd reset time
+	reset := time.Now().AddDate(10, 0, 0)
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+		return newSecondaryLimitResponse(t, header), nil
+	})
+
+	horizons := map[string]struct {
+		opts    []github_ratelimit.Option
+		horizon time.Duration
+	}{
+		"default": {nil, github_ratelimit.DefaultMaxResetHorizon},
+		"custom":  {[]github_ratelimit.Option{github_ratelimit.WithMaxResetHorizon(time.Minute)}, time.Minute},
+	}
+	for name, tc := range horizons {
+		// detect the limit without sleeping, to inspect the effective reset time
+		var sleepUntil time.Time
+		onExceeded := func(ctx *github_ratelimit.CallbackContext) {
+			sleepUntil = *ctx.SleepUntil
+		}
+		opts := append(tc.opts, github_ratelimit.WithSingleSleepLimit(0, onExceeded))
+		c, err := github_ratelimit.NewRateLimitWaiterClient(base, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+		if got, max := time.Until(sleepUntil), tc.horizon; got <= 0 || got > max {
+			t.Fatal(name, got, max)
+		}
+	}
+}
+
+func TestPreciseSleep(t *testing.T) {
+	t.Parallel()
+
+	// a single secondary rate limit of (just under) a second (see newLimitOnceTransport)
+	totalSleep := func(opts ...github_ratelimit.Option) time.Duration {
+		var total time.Duration
+		callback := func(cbContext *github_ratelimit.CallbackContext) {
+			total = *cbContext.TotalSleepTime
+		}
+		opts = append(opts, github_ratelimit.WithLimitDetectedCallback(callback))
+		c, err := github_ratelimit.NewRateLimitWaiterClient(newLimitOnceTransport(t, nil), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tBefore := time.Now()
+		if _, err := c.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+		if got, max := time.Since(tBefore), 1500*time.Millisecond; got > max {
+			t.Fatal(got, max)
+		}
+		return total
+	}
+
+	// rounded up to whole seconds by default
+	if got, want := totalSleep(), time.Second; got != want {
+		t.Fatal(got, want)
+	}
+	// exact in precise mode
+	if got, min, max := totalSleep(github_ratelimit.WithPreciseSleep()), time.Second/2, time.Second; got <= min || got >= max {
+		t.Fatalf("unexpected total sleep: %v < %v < %v", min, got, max)
+	}
+}
+
+func TestOscillationGuard(t *testing.T) {
+	t.Parallel()
+	const maxSleep = 3 * time.Second
+
+	// back-to-back short limits: every other request is limited (for a second)
+	base := newLimitEveryOtherTransport(t)
+
+	var lock sync.Mutex
+	var sleeps []time.Duration
+	callback := func(cbContext *github_ratelimit.CallbackContext) {
+		lock.Lock()
+		defer lock.Unlock()
+		sleeps = append(sleeps, time.Until(*cbContext.SleepUntil).Round(time.Second))
+	}
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithOscillationGuard(2, 2, maxSleep),
+		github_ratelimit.WithLimitDetectedCallback(callback),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 3; index++ {
+		if _, err := c.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the sleep grows from the second limit on, up to the max
+	lock.Lock()
+	defer lock.Unlock()
+	if got, want := fmt.Sprint(sleeps), fmt.Sprint([]time.Duration{time.Second, 2 * time.Second, maxSleep}); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestSleepBudgetPerWindow(t *testing.T) {
+	t.Parallel()
+	const window = 3 * time.Second
+
+	// the first attempt of each client request is limited (for a second),
+	// i.e., the 1st (then retried), the 3rd (returned as-is) and the 4th (then retried) requests.
+	var requests atomic.Int64
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		switch requests.Add(1) {
+		case 1, 3, 4:
+			header := http.Header{}
+			header.Set(github_ratelimit.HeaderRetryAfter, "1")
+			return newSecondaryLimitResponse(t, header), nil
+		}
+		return (&nopServer{}).RoundTrip(r)
+	})
+
+	var exceeded atomic.Int64
+	callback := func(*github_ratelimit.CallbackContext) {
+		exceeded.Add(1)
+	}
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base, github_ratelimit.WithSleepBudgetPerWindow(time.Second, window, callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() *github_ratelimit.LimitDecision {
+		resp, err := c.Get("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return github_ratelimit.GetLimitDecision(resp)
+	}
+
+	// the first sleep is within the budget
+	tBefore := time.Now()
+	if got, want := get().Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+
+	// the budget of the window is exhausted - returned without sleeping
+	if got, want := get().Decision, github_ratelimit.DecisionReturn; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := exceeded.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+
+	// once the window rolls, the budget recovers
+	time.Sleep(time.Until(tBefore.Add(window + time.Second/4)))
+	if got, want := get().Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestFairRelease(t *testing.T) {
+	t.Parallel()
+	const spacing = 200 * time.Millisecond
+	const parallelReqs = 5
+
+	// a single secondary rate limit (of a second) for the first request, recording the order of the others
+	var lock sync.Mutex
+	var order []string
+	var times []time.Time
+	limitOnce := newLimitOnceTransport(t, nil)
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := limitOnce.RoundTrip(r)
+		if err != nil || resp.StatusCode == http.StatusForbidden {
+			return resp, err
+		}
+		lock.Lock()
+		order = append(order, r.URL.Path)
+		times = append(times, time.Now())
+		lock.Unlock()
+		return resp, nil
+	})
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base, github_ratelimit.WithFairRelease(spacing))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// trigger the rate limit
+	errChan := make(chan error, parallelReqs+1)
+	go func() {
+		_, err := c.Get("/0")
+		errChan <- err
+	}()
+	time.Sleep(spacing / 2)
+
+	// requests arrive in order during the rate limit
+	for index := 1; index <= parallelReqs; index++ {
+		path := fmt.Sprintf("/%d", index)
+		go func() {
+			_, err := c.Get(path)
+			errChan <- err
+		}()
+		time.Sleep(spacing / 10)
+	}
+	for index := 0; index <= parallelReqs; index++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got, want := fmt.Sprint(order), "[/0 /1 /2 /3 /4 /5]"; got != want {
+		t.Fatal(got, want)
+	}
+	for index := 1; index < len(times); index++ {
+		if got, min := times[index].Sub(times[index-1]), spacing*3/4; got < min {
+			t.Fatal(index, got, min)
+		}
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	t.Parallel()
+	// make sure the next block starts after the late release
+	const every = 5 * time.Second
+	const sleep = 1 * time.Second
+	const actualSleep = 3 * time.Second
+	const parallelReqs = 20
+
+	// the injecter releases the block later than advertised,
+	// so the requests that resume after the advertised sleep are limited again.
+	newClient := func(opts ...github_ratelimit.Option) (http.RoundTripper, *http.Client) {
+		i := setupInjecterWithOptions(t, SecondaryRateLimitInjecterOptions{
+			Every:       every,
+			Sleep:       sleep,
+			ActualSleep: actualSleep,
+		}, nil)
+		c, err := github_ratelimit.NewRateLimitWaiterClient(i, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return i, c
+	}
+
+	// runs concurrently, so errors are returned rather than failing the test from another goroutine
+	abuseAttempts := func(i http.RoundTripper, c *http.Client) (int, error) {
+		// initialize injecter timing
+		_, _ = c.Get("/")
+		waitForNextSleep(i)
+
+		// issue concurrent requests during the (extended) rate limit
+		errChan := make(chan error, parallelReqs)
+		for index := 0; index < parallelReqs; index++ {
+			go func() {
+				_, err := c.Get("/")
+				errChan <- err
+			}()
+		}
+		var firstErr error
+		for index := 0; index < parallelReqs; index++ {
+			if err := <-errChan; err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		return i.(*SecondaryRateLimitInjecter).AbuseAttempts, firstErr
+	}
+
+	defaultInjecter, defaultClient := newClient()
+	coalescedInjecter, coalescedClient := newClient(github_ratelimit.WithCoalescing())
+	var defaultAttempts, coalescedAttempts int
+	var defaultErr, coalescedErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defaultAttempts, defaultErr = abuseAttempts(defaultInjecter, defaultClient)
+	}()
+	go func() {
+		defer wg.Done()
+		coalescedAttempts, coalescedErr = abuseAttempts(coalescedInjecter, coalescedClient)
+	}()
+	wg.Wait()
+
+	if defaultErr != nil {
+		t.Fatal(defaultErr)
+	}
+	if coalescedErr != nil {
+		t.Fatal(coalescedErr)
+	}
+	if coalescedAttempts >= defaultAttempts {
+		t.Fatal(coalescedAttempts, defaultAttempts)
+	}
+	t.Logf("abuse attempts: %v (coalesced) / %v (default)", coalescedAttempts, defaultAttempts)
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(github_ratelimit.EnvSingleSleepLimit, "1m")
+	t.Setenv(github_ratelimit.EnvTotalSleepLimit, "1h")
+	t.Setenv(github_ratelimit.EnvMaxRetries, "3")
+
+	opts, err := github_ratelimit.OptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config github_ratelimit.SecondaryRateLimitConfig
+	config.ApplyOptions(opts...)
+
+	if config.IsAboveSingleSleepLimit(time.Minute) || !config.IsAboveSingleSleepLimit(time.Minute+1) {
+		t.Fatal("unexpected single sleep limit")
+	}
+	if config.IsAboveTotalSleepLimit(time.Minute, time.Hour-time.Minute) || !config.IsAboveTotalSleepLimit(time.Minute, time.Hour) {
+		t.Fatal("unexpected total sleep limit")
+	}
+	if config.IsAboveGlobalMaxRetries(3) || !config.IsAboveGlobalMaxRetries(4) {
+		t.Fatal("unexpected global max retries")
+	}
+
+	// the limits from the environment keep the callbacks set in code
+	t.Setenv(github_ratelimit.EnvSingleSleepLimit, "100ms")
+	opts, err = github_ratelimit.OptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exceeded atomic.Int64
+	onExceeded := func(*github_ratelimit.CallbackContext) {
+		exceeded.Add(1)
+	}
+	opts = append([]github_ratelimit.Option{github_ratelimit.WithSingleSleepLimit(time.Hour, onExceeded)}, opts...)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(newLimitOnceTransport(t, nil), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exceeded.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+
+	// invalid values are reported
+	t.Setenv(github_ratelimit.EnvTotalSleepLimit, "an hour")
+	if _, err := github_ratelimit.OptionsFromEnv(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHardConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+	const every = 5 * time.Second
+	const sleep = 1 * time.Second
+	const limit = 2
+	const parallelReqs = 10
+
+	// track the in-flight requests and the time they were sent
+	var lock sync.Mutex
+	var inFlight, maxInFlight int
+	var sent []time.Time
+	tracker := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		sent = append(sent, time.Now())
+		lock.Unlock()
+
+		resp, err := (&nopServer{}).RoundTrip(r)
+		time.Sleep(50 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return resp, err
+	})
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, tracker)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithHardConcurrencyLimit(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// trigger the rate limit
+	errChan := make(chan error, parallelReqs+1)
+	go func() {
+		_, err := c.Get("/")
+		errChan <- err
+	}()
+	time.Sleep(sleep / 4)
+
+	// acquisitions during the rate limit wait for it to pass
+	lock.Lock()
+	sentBefore := len(sent)
+	lock.Unlock()
+	for index := 0; index < parallelReqs; index++ {
+		go func() {
+			_, err := c.Get("/")
+			errChan <- err
+		}()
+	}
+	for index := 0; index < parallelReqs+1; index++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if maxInFlight > limit {
+		t.Fatal(maxInFlight, limit)
+	}
+	sleepEnd := i.(*SecondaryRateLimitInjecter).CurrentSleepEnd()
+	for _, sentAt := range sent[sentBefore:] {
+		if sentAt.Before(sleepEnd) {
+			t.Fatalf("request sent during the rate limit: %v < %v", sentAt, sleepEnd)
+		}
+	}
+}
+
+func TestConcurrencyLimitRestartReservesOnce(t *testing.T) {
+	t.Parallel()
+	const rpm = 3
+
+	hold := make(chan struct{})
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Path {
+		case "/hold":
+			<-hold
+		case "/limit":
+			header := http.Header{}
+			header.Set(github_ratelimit.HeaderRetryAfter, "1")
+			return newSecondaryLimitResponse(t, header), nil
+		}
+		return (&nopServer{}).RoundTrip(r)
+	})
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithHardConcurrencyLimit(1),
+		github_ratelimit.WithRequestsPerMinute(rpm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the only slot, and queue a request behind it
+	errChan := make(chan error, 2)
+	for _, path := range []string{"/hold", "/queued"} {
+		path := path
+		go func() {
+			_, err := c.Get(path)
+			errChan <- err
+		}()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// a limit is detected while the queued request waits for the slot, so it restarts once the slot is free
+	req, err := http.NewRequestWithContext(github_ratelimit.WithBypass(context.Background()), http.MethodGet, "/limit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	close(hold)
+	for index := 0; index < 2; index++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the restarted request reserved a single request in the window, so the last one fits right away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, "/last", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchedEvents(t *testing.T) {
+	t.Parallel()
+
+	type batches struct {
+		lock    sync.Mutex
+		batches [][]github_ratelimit.LimitEvent
+	}
+	run := func(interval time.Duration, maxBatch int, closeEarly bool) [][]github_ratelimit.LimitEvent {
+		var b batches
+		handler := func(events []github_ratelimit.LimitEvent) {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			b.batches = append(b.batches, events)
+		}
+		r, err := github_ratelimit.NewRateLimitWaiter(newLimitOnceTransport(t, nil), github_ratelimit.WithBatchedEvents(interval, maxBatch, handler))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &http.Client{
+			Transport: r,
+		}
+
+		if closeEarly {
+			// close during the sleep - only the activation is flushed
+			time.AfterFunc(time.Second/2, func() {
+				r.Close()
+			})
+		}
+		if _, err := c.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second / 2)
+		r.Close()
+
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		return b.batches
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	var byCount, byInterval, byClose [][]github_ratelimit.LimitEvent
+	go func() {
+		defer wg.Done()
+		byCount = run(time.Hour, 2, false)
+	}()
+	go func() {
+		defer wg.Done()
+		byInterval = run(time.Second/4, 100, false)
+	}()
+	go func() {
+		defer wg.Done()
+		byClose = run(time.Hour, 100, true)
+	}()
+	wg.Wait()
+
+	// the activation and the clearance are delivered together once the batch is full
+	if got, want := fmt.Sprint(batchSizes(byCount)), "[2]"; got != want {
+		t.Fatal(got, want)
+	}
+	// the activation and the clearance are delivered separately, a second apart
+	if got, want := fmt.Sprint(batchSizes(byInterval)), "[1 1]"; got != want {
+		t.Fatal(got, want)
+	}
+	// the pending activation is flushed on close
+	if got, want := fmt.Sprint(batchSizes(byClose)), "[1]"; got != want {
+		t.Fatal(got, want)
+	}
+	if !byClose[0][0].Active {
+		t.Fatalf("unexpected event: %v", byClose[0][0])
+	}
+}
+
+func batchSizes(batches [][]github_ratelimit.LimitEvent) []int {
+	var sizes []int
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func TestServiceUnavailableBackoff(t *testing.T) {
+	t.Parallel()
+	const maxRetries = 2
+
+	var requests atomic.Int64
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	})
+
+	var detected, unavailable atomic.Int64
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithLimitDetectedCallback(func(*github_ratelimit.CallbackContext) {
+			detected.Add(1)
+		}),
+		github_ratelimit.WithServiceUnavailableBackoff(maxRetries, func(ctx *github_ratelimit.CallbackContext) {
+			if ctx.SleepUntil == nil || ctx.Response.StatusCode != http.StatusServiceUnavailable {
+				t.Error("unexpected callback context")
+			}
+			unavailable.Add(1)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the retries are bounded, so the last response is returned
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := requests.Load(), int64(maxRetries+1); got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := unavailable.Load(), int64(maxRetries); got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := detected.Load(), int64(0); got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, maxRetries; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestConflictingOptions(t *testing.T) {
+	t.Parallel()
+
+	conflicts := map[string][]github_ratelimit.Option{
+		"error with probe": {
+			github_ratelimit.WithSecondaryLimitAsError(),
+			github_ratelimit.WithProbeRetry(time.Second, 2),
+		},
+		"error with coalescing": {
+			github_ratelimit.WithSecondaryLimitAsError(),
+			github_ratelimit.WithCoalescing(),
+		},
+		"error with fair release": {
+			github_ratelimit.WithSecondaryLimitAsError(),
+			github_ratelimit.WithFairRelease(time.Second),
+		},
+		"min sleep above single limit": {
+			github_ratelimit.WithMinSleep(time.Minute),
+			github_ratelimit.WithSingleSleepLimit(time.Second, nil),
+		},
+	}
+	for name, opts := range conflicts {
+		if _, err := github_ratelimit.NewRateLimitWaiterClient(nil, opts...); !errors.Is(err, github_ratelimit.ErrConflictingOptions) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if _, err := github_ratelimit.NewLimiter(opts...); !errors.Is(err, github_ratelimit.ErrConflictingOptions) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+	}
+
+	// per-request configs are validated as well (on top of the client config, or instead of it)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(&nopServer{}, github_ratelimit.WithCoalescing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, ctx := range map[string]context.Context{
+		"override": github_ratelimit.WithOverrideConfig(context.Background(), github_ratelimit.WithSecondaryLimitAsError()),
+		"replace": github_ratelimit.WithReplaceConfig(context.Background(),
+			github_ratelimit.WithSecondaryLimitAsError(),
+			github_ratelimit.WithProbeRetry(time.Second, 2)),
+	} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Do(req); !errors.Is(err, github_ratelimit.ErrConflictingOptions) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+	}
+
+	// compatible options are accepted
+	if _, err := github_ratelimit.NewRateLimitWaiterClient(nil,
+		github_ratelimit.WithMinSleep(time.Second),
+		github_ratelimit.WithSingleSleepLimit(time.Minute, nil),
+		github_ratelimit.WithProbeRetry(time.Second, 2),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetSingleSleepLimit(t *testing.T) {
+	t.Parallel()
+
+	var exceeded atomic.Int64
+	onExceeded := func(*github_ratelimit.CallbackContext) {
+		exceeded.Add(1)
+	}
+	r, err := github_ratelimit.NewRateLimitWaiter(newLimitEveryOtherTransport(t), github_ratelimit.WithSingleSleepLimit(time.Minute, onExceeded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// the original limit allows the sleep
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := exceeded.Load(), int64(0); got != want {
+		t.Fatal(got, want)
+	}
+
+	// tighten the limit at runtime - the next limit is not slept for
+	if err := r.SetSingleSleepLimit(0, onExceeded); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := github_ratelimit.GetLimitDecision(resp); !decision.Limited || decision.Retries != 0 {
+		t.Fatal(decision.Limited, decision.Retries)
+	}
+	if got, want := exceeded.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestSetTotalSleepLimit(t *testing.T) {
+	t.Parallel()
+
+	var exceeded atomic.Int64
+	onExceeded := func(*github_ratelimit.CallbackContext) {
+		exceeded.Add(1)
+	}
+	r, err := github_ratelimit.NewRateLimitWaiter(newLimitEveryOtherTransport(t), github_ratelimit.WithTotalSleepLimit(time.Hour, onExceeded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// the original limit allows the sleep (of a second)
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := exceeded.Load(), int64(0); got != want {
+		t.Fatal(got, want)
+	}
+
+	// tighten the limit at runtime to the time already slept - the next limit is not slept for
+	if err := r.SetTotalSleepLimit(time.Second, onExceeded); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := github_ratelimit.GetLimitDecision(resp); !decision.Limited || decision.Retries != 0 {
+		t.Fatal(decision.Limited, decision.Retries)
+	}
+	if got, want := exceeded.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+}
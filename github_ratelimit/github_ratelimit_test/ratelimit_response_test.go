@@ -0,0 +1,983 @@
+package github_ratelimit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofri/go-github-ratelimit/github_ratelimit"
+)
+
+const PermissionDeniedBody = `{"message": "Resource not accessible by integration", "documentation_url": "https://docs.github.com/rest/repos/repos#get-a-repository"}`
+
+// staticResponder is a round tripper that always responds with the given status, headers and body.
+type staticResponder struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (s *staticResponder) RoundTrip(r *http.Request) (*http.Response, error) {
+	header := http.Header{}
+	for k, v := range s.header {
+		header[k] = v
+	}
+	return &http.Response{
+		StatusCode:    s.statusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(s.body)),
+		ContentLength: -1,
+		Request:       r,
+	}, nil
+}
+
+func TestBodyLengthAfterDetection(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(PermissionDeniedBody)
+	header := http.Header{}
+	// a stale content-length, e.g., as left by a transparent decompression layer
+	header.Set(github_ratelimit.HeaderContentLength, strconv.Itoa(len(body)*2))
+	base := &staticResponder{
+		statusCode: http.StatusForbidden,
+		header:     header,
+		body:       body,
+	}
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.ContentLength, int64(len(body)); got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := resp.Header.Get(github_ratelimit.HeaderContentLength), strconv.Itoa(len(body)); got != want {
+		t.Fatal(got, want)
+	}
+
+	var decoded github_ratelimit.SecondaryRateLimitBody
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.IsSecondaryRateLimit() {
+		t.Fatalf("unexpected secondary rate limit body: %v", decoded)
+	}
+}
+
+func newSecondaryLimitResponse(t *testing.T, header http.Header) *http.Response {
+	body, err := getSecondaryRateLimitBody("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     header,
+		Body:       body,
+	}
+}
+
+// newLimitOnceTransport responds with a secondary rate limit (with the given header) to the first request only.
+// a nil header defaults to a retry-after of a second.
+func newLimitOnceTransport(t *testing.T, header http.Header) http.RoundTripper {
+	if header == nil {
+		header = http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+	}
+
+	return newRespondOnceTransport(func() *http.Response {
+		return newSecondaryLimitResponse(t, header)
+	})
+}
+
+// newLimitEveryOtherTransport responds with a secondary rate limit (with a retry-after of a second)
+// to every other request, starting with the first one, i.e., each limited request passes once retried.
+func newLimitEveryOtherTransport(t *testing.T) http.RoundTripper {
+	var requests atomic.Int64
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if requests.Add(1)%2 == 0 {
+			return (&nopServer{}).RoundTrip(r)
+		}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return newSecondaryLimitResponse(t, header), nil
+	})
+}
+
+// newRespondOnceTransport responds with the given response to the first request only (e.g., a limit-like response).
+func newRespondOnceTransport(respond func() *http.Response) http.RoundTripper {
+	var requests atomic.Int64
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if requests.Add(1) > 1 {
+			return (&nopServer{}).RoundTrip(r)
+		}
+		return respond(), nil
+	})
+}
+
+func TestDetectSecondaryLimit(t *testing.T) {
+	t.Parallel()
+
+	// a secondary rate limit with a retry-after header
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderRetryAfter, "5")
+	resetTime, ok := github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, header))
+	if !ok || resetTime == nil {
+		t.Fatal(resetTime, ok)
+	}
+	if got, min, max := time.Until(*resetTime), 4*time.Second, 5*time.Second; got <= min || got > max {
+		t.Fatalf("unexpected reset time: %v < %v <= %v", min, got, max)
+	}
+
+	// a secondary rate limit with a x-ratelimit-reset header
+	reset := time.Now().Add(time.Minute).Truncate(time.Second)
+	header = http.Header{}
+	header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, header))
+	if !ok || resetTime == nil {
+		t.Fatal(resetTime, ok)
+	}
+	if got, want := *resetTime, reset; !got.Equal(want) {
+		t.Fatal(got, want)
+	}
+
+	// a secondary rate limit without a reset header
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, nil))
+	if !ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+
+	// not a secondary rate limit
+	resp := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader([]byte(PermissionDeniedBody))),
+	}
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(resp)
+	if ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+}
+
+func TestFractionalRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	// a fractional retry-after is rounded up to whole seconds
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderRetryAfter, "2.3")
+	resetTime, ok := github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, header))
+	if !ok || resetTime == nil {
+		t.Fatal(resetTime, ok)
+	}
+	if got, min, max := time.Until(*resetTime), 2*time.Second, 3*time.Second; got <= min || got > max {
+		t.Fatalf("unexpected reset time: %v < %v <= %v", min, got, max)
+	}
+
+	// the waiter sleeps for the rounded up duration
+	base := newLimitOnceTransport(t, header)
+	var slept time.Duration
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithLimitDetectedCallback(func(ctx *github_ratelimit.CallbackContext) {
+			slept = *ctx.TotalSleepTime
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := slept, 3*time.Second; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestMillisecondXRateLimitReset(t *testing.T) {
+	t.Parallel()
+
+	reset := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	values := map[string]time.Time{
+		"seconds":      reset.Truncate(time.Second),
+		"milliseconds": reset,
+	}
+	for form, want := range values {
+		value := want.Unix()
+		if form == "milliseconds" {
+			value = want.UnixMilli()
+		}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(value, 10))
+		resetTime, ok := github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, header))
+		if !ok || resetTime == nil {
+			t.Fatal(form, resetTime, ok)
+		}
+		if got := *resetTime; !got.Equal(want) {
+			t.Fatal(form, got, want)
+		}
+	}
+}
+
+func TestMillisecondResetThreshold(t *testing.T) {
+	t.Parallel()
+
+	// far enough in the future for the parallel subtests, which may wait for a while before they run
+	reset := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	asSeconds := time.Unix(reset.UnixMilli(), 0)
+	tests := map[string]struct {
+		opts []github_ratelimit.Option
+		want time.Time
+	}{
+		"default": {nil, reset},
+		// interpreted as seconds
+		"disabled": {[]github_ratelimit.Option{github_ratelimit.WithMillisecondResetThreshold(0)}, asSeconds},
+		"raised":   {[]github_ratelimit.Option{github_ratelimit.WithMillisecondResetThreshold(reset.UnixMilli() + 1)}, asSeconds},
+	}
+
+	for name, tc := range tests {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			header := http.Header{}
+			header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(reset.UnixMilli(), 10))
+			opts := append([]github_ratelimit.Option{github_ratelimit.WithSecondaryLimitAsError()}, tc.opts...)
+			c, err := github_ratelimit.NewRateLimitWaiterClient(newLimitOnceTransport(t, header), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var limitErr *github_ratelimit.SecondaryRateLimitError
+			if _, err := c.Get("/"); !errors.As(err, &limitErr) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got, want := limitErr.ResetTime, tc.want; !got.Equal(want) {
+				t.Fatal(got, want)
+			}
+		})
+	}
+}
+
+func TestResourceSecondaryLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		remaining string
+		resource  string
+		limited   bool
+	}{
+		// a secondary rate limit on graphql, with a remaining quota for the resource
+		{name: "remaining", remaining: "4321", resource: "graphql", limited: true},
+		// an exhausted quota of another resource does not make it a primary rate limit of graphql
+		{name: "other-resource", remaining: "0", resource: "core", limited: true},
+		// an exhausted graphql quota is a primary rate limit
+		{name: "exhausted", remaining: "0", resource: "graphql", limited: false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			header := http.Header{}
+			header.Set(github_ratelimit.HeaderRetryAfter, "1")
+			header.Set(github_ratelimit.HeaderXRateLimitRemaining, tc.remaining)
+			header.Set(github_ratelimit.HeaderXRateLimitResource, tc.resource)
+			base := newLimitOnceTransport(t, header)
+
+			c, err := github_ratelimit.NewRateLimitWaiterClient(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Post("/graphql", "application/json", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			decision := github_ratelimit.GetLimitDecision(resp)
+			if got, want := decision.Limited, tc.limited; got != want {
+				t.Fatalf("unexpected decision: %+v", decision)
+			}
+			if tc.limited {
+				if got, want := decision.Resource, tc.resource; got != want {
+					t.Fatal(got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLenientDetection(t *testing.T) {
+	t.Parallel()
+
+	// a 403 with retry-after, but a body that is not a secondary rate limit
+	retryAfter := func() *http.Response {
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader([]byte(PermissionDeniedBody))),
+		}
+	}
+	get := func(opts ...github_ratelimit.Option) *github_ratelimit.LimitDecision {
+		c, err := github_ratelimit.NewRateLimitWaiterClient(newRespondOnceTransport(retryAfter), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Get("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return github_ratelimit.GetLimitDecision(resp)
+	}
+
+	// strict (default) - the body is validated, so the response is returned as-is
+	if decision := get(); decision.Limited || decision.Retries != 0 {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+	// lenient - waited for and retried
+	if decision := get(github_ratelimit.WithLenientDetection()); !decision.Limited || decision.Retries != 1 {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+}
+
+func TestPastXRateLimitReset(t *testing.T) {
+	t.Parallel()
+
+	// a reset time in the past (e.g., clock drift) is not an active limit
+	past := time.Now().Add(-time.Minute)
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(past.Unix(), 10))
+	resetTime, ok := github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, header))
+	if !ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+
+	// the waiter returns the response without storing the reset time
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return newSecondaryLimitResponse(t, header.Clone()), nil
+	})
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	decision := github_ratelimit.GetLimitDecision(resp)
+	if decision.Decision != github_ratelimit.DecisionPass || decision.ResetTime != nil {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+}
+
+func TestLocalizedSecondaryLimit(t *testing.T) {
+	t.Parallel()
+
+	// a localized message with a valid documentation URL
+	body := github_ratelimit.SecondaryRateLimitBody{
+		Message:     "Sie haben ein sekundäres Ratenlimit überschritten",
+		DocumentURL: "https://docs.github.com/de/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits",
+	}
+	if !body.IsSecondaryRateLimit() {
+		t.Fatalf("localized secondary rate limit not detected: %v", body)
+	}
+
+	// a localized message without a documentation URL - detected only by waiters configured with the prefix
+	const localizedMessage = "Vous avez dépassé une limite de débit secondaire"
+	body = github_ratelimit.SecondaryRateLimitBody{
+		Message: localizedMessage,
+	}
+	if body.IsSecondaryRateLimit() {
+		t.Fatalf("unexpected secondary rate limit: %v", body)
+	}
+	rawBody, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderRetryAfter, "1")
+	base := &staticResponder{
+		statusCode: http.StatusForbidden,
+		header:     header,
+		body:       rawBody,
+	}
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base, github_ratelimit.WithSecondaryLimitAsError())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("/"); err != nil {
+		t.Fatalf("unexpected secondary rate limit: %v", err)
+	}
+
+	c, err = github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithSecondaryLimitAsError(),
+		github_ratelimit.WithSecondaryRateLimitMessages(localizedMessage),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var limitErr *github_ratelimit.SecondaryRateLimitError
+	if _, err := c.Get("/"); !errors.As(err, &limitErr) {
+		t.Fatalf("configured secondary rate limit message not detected: %v", err)
+	}
+}
+
+func BenchmarkSecondaryDetection(b *testing.B) {
+	base := &staticResponder{
+		statusCode: http.StatusForbidden,
+		header:     http.Header{},
+		body:       bytes.Repeat([]byte(" "), 64*1024),
+	}
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarks := []struct {
+		name string
+		opts []github_ratelimit.Option
+	}{
+		{"enabled", nil},
+		// sleeping is disabled and there is no callback - detection is effectively off
+		{"disabled", []github_ratelimit.Option{github_ratelimit.WithSingleSleepLimit(0, nil)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			r, err := github_ratelimit.NewRateLimitWaiter(base, bm.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := r.RoundTrip(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPermissionDeniedNotDetected(t *testing.T) {
+	t.Parallel()
+
+	bodies := []github_ratelimit.SecondaryRateLimitBody{
+		{
+			Message:     "Resource not accessible by integration",
+			DocumentURL: "https://docs.github.com/rest/repos/repos#get-a-repository",
+		},
+		{
+			Message:     "Must have admin rights to Repository.",
+			DocumentURL: "https://docs.github.com/rest/repos/repos#update-a-repository",
+		},
+		{
+			// unrelated documentation that merely refers to the rate limit docs
+			Message:     "Resource not accessible by personal access token",
+			DocumentURL: "https://docs.github.com/rest/overview/permissions-required-for-fine-grained-personal-access-tokens?from=secondary-rate-limits",
+		},
+	}
+
+	for _, body := range bodies {
+		if body.IsSecondaryRateLimit() {
+			t.Fatalf("unexpected secondary rate limit: %v", body)
+		}
+
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "5")
+		resp := &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader(raw)),
+		}
+		if resetTime, ok := github_ratelimit.DetectSecondaryLimit(resp); ok || resetTime != nil {
+			t.Fatalf("unexpected secondary rate limit: %v (%v, %v)", body, resetTime, ok)
+		}
+	}
+
+	// make sure the legitimate documentation URLs are still detected
+	for _, docURL := range SecondaryRateLimitDocumentationURLs {
+		body := github_ratelimit.SecondaryRateLimitBody{
+			Message:     "localized or modified message",
+			DocumentURL: docURL,
+		}
+		if !body.IsSecondaryRateLimit() {
+			t.Fatalf("expected secondary rate limit: %v", body)
+		}
+	}
+}
+
+func TestNonJSONDetection(t *testing.T) {
+	t.Parallel()
+
+	newResponse := func(retryAfter string) (*http.Response, *readTrackingBody) {
+		body := &readTrackingBody{Reader: bytes.NewReader(bytes.Repeat([]byte{0}, 1024))}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderContentType, "application/octet-stream")
+		if retryAfter != "" {
+			header.Set(github_ratelimit.HeaderRetryAfter, retryAfter)
+		}
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     header,
+			Body:       body,
+		}, body
+	}
+
+	// a non-JSON 403 with retry-after is honored via the headers
+	resp, body := newResponse("5")
+	resetTime, ok := github_ratelimit.DetectSecondaryLimit(resp)
+	if !ok || resetTime == nil {
+		t.Fatal(resetTime, ok)
+	}
+	if body.read.Load() {
+		t.Fatalf("non-JSON body was read")
+	}
+
+	// a non-JSON 403 without rate limit headers is not a limit
+	resp, body = newResponse("")
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(resp)
+	if ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+	if body.read.Load() {
+		t.Fatalf("non-JSON body was read")
+	}
+
+	// a non-JSON 403 with only x-ratelimit-reset (the primary rate limit reset) is not a limit
+	resp, _ = newResponse("")
+	resp.Header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(resp)
+	if ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+
+	// a JSON content type (with parameters) is still detected by the body
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderContentType, "application/json; charset=utf-8")
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(newSecondaryLimitResponse(t, header))
+	if !ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+}
+
+func TestBodylessTooManyRequests(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderRetryAfter, "5")
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     header,
+		Body:       http.NoBody,
+	}
+	resetTime, ok := github_ratelimit.DetectSecondaryLimit(resp)
+	if !ok || resetTime == nil {
+		t.Fatal(resetTime, ok)
+	}
+
+	// a bodyless 403 with retry-after is still ambiguous
+	resp = &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     header,
+		Body:       http.NoBody,
+	}
+	resetTime, ok = github_ratelimit.DetectSecondaryLimit(resp)
+	if ok || resetTime != nil {
+		t.Fatal(resetTime, ok)
+	}
+}
+
+func TestNilBodyNotDetected(t *testing.T) {
+	t.Parallel()
+
+	// a 403 with a nil body (as returned by some mocks)
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     header,
+		}, nil
+	})
+
+	r, err := github_ratelimit.NewRateLimitWaiter(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, http.StatusForbidden; got != want {
+		t.Fatal(got, want)
+	}
+	if resp.Body != nil {
+		t.Fatalf("unexpected body: %v", resp.Body)
+	}
+}
+
+func TestTrailerDetection(t *testing.T) {
+	t.Parallel()
+
+	// far enough in the future for the parallel subtests, which may wait for a while before they run
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name        string
+		statusCode  int
+		contentType string
+		body        string
+		trailer     string
+		value       string
+		expected    time.Time
+	}{
+		{
+			name:        "json",
+			statusCode:  http.StatusForbidden,
+			contentType: "application/json",
+			body:        `{"message":"` + SecondaryRateLimitMessage + `"}`,
+			trailer:     github_ratelimit.HeaderXRateLimitReset,
+			value:       strconv.FormatInt(reset.Unix(), 10),
+			expected:    reset,
+		},
+		{
+			name:        "too-many-requests",
+			statusCode:  http.StatusTooManyRequests,
+			contentType: "application/json",
+			body:        `{}`,
+			trailer:     github_ratelimit.HeaderRetryAfter,
+			value:       "60",
+		},
+		{
+			name:        "non-json",
+			statusCode:  http.StatusForbidden,
+			contentType: "text/html",
+			body:        "<html></html>",
+			trailer:     github_ratelimit.HeaderRetryAfter,
+			value:       "60",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// trailers are only sent with a chunked response, and only populated once the body is read
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", tc.trailer)
+				w.Header().Set(github_ratelimit.HeaderContentType, tc.contentType)
+				w.WriteHeader(tc.statusCode)
+				_, _ = io.WriteString(w, tc.body)
+				w.(http.Flusher).Flush()
+				w.Header().Set(tc.trailer, tc.value)
+			}))
+			t.Cleanup(server.Close)
+
+			start := time.Now()
+			resp, err := server.Client().Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			resetTime, ok := github_ratelimit.DetectSecondaryLimit(resp)
+			if !ok || resetTime == nil {
+				t.Fatal(resetTime, ok)
+			}
+			if tc.expected.IsZero() {
+				if got, earliest := *resetTime, start.Add(time.Minute); got.Before(earliest) {
+					t.Fatal(got, earliest)
+				}
+			} else if got, want := *resetTime, tc.expected; !got.Equal(want) {
+				t.Fatal(got, want)
+			}
+
+			// the buffered body is still readable
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(body), tc.body; got != want {
+				t.Fatal(got, want)
+			}
+		})
+	}
+}
+
+// closeCountingBody is a response body that counts the calls to Close.
+type closeCountingBody struct {
+	io.Reader
+	closed atomic.Int64
+}
+
+func (b *closeCountingBody) Close() error {
+	b.closed.Add(1)
+	return nil
+}
+
+func TestResponseBodyPreservation(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte(PermissionDeniedBody)
+	body := &closeCountingBody{Reader: bytes.NewReader(raw)}
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{},
+			Body:       body,
+		}, nil
+	})
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Fatalf("body mismatch: %q != %q", got, raw)
+	}
+
+	// closing the returned body (even repeatedly) is safe, and the original body is closed exactly once
+	for index := 0; index < 2; index++ {
+		if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := body.closed.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestResetPreference(t *testing.T) {
+	t.Parallel()
+
+	body, err := json.Marshal(github_ratelimit.SecondaryRateLimitBody{
+		Message:     SecondaryRateLimitMessage,
+		DocumentURL: SecondaryRateLimitDocumentationURLs[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// conflicting headers: retry-after is earlier than x-ratelimit-reset
+	const retryAfter = 10 * time.Second
+	reset := time.Now().Add(time.Minute).Truncate(time.Second)
+	header := http.Header{}
+	header.Set(github_ratelimit.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())))
+	header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+	base := &staticResponder{
+		statusCode: http.StatusForbidden,
+		header:     header,
+		body:       body,
+	}
+
+	tests := []struct {
+		preference github_ratelimit.ResetPreference
+		wantReset  bool
+	}{
+		{github_ratelimit.ResetPreferenceRetryAfterFirst, false},
+		{github_ratelimit.ResetPreferenceResetFirst, true},
+		{github_ratelimit.ResetPreferenceMaxOf, true},
+		{github_ratelimit.ResetPreferenceMinOf, false},
+	}
+	for _, test := range tests {
+		c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+			github_ratelimit.WithSecondaryLimitAsError(),
+			github_ratelimit.WithResetPreference(test.preference))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.Get("/")
+		var limitErr *github_ratelimit.SecondaryRateLimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		limitErr.Response.Body.Close()
+
+		if test.wantReset {
+			if got, want := limitErr.ResetTime, reset; !got.Equal(want) {
+				t.Fatal(test.preference, got, want)
+			}
+		} else {
+			if got, min, max := time.Until(limitErr.ResetTime), retryAfter-time.Second, retryAfter; got <= min || got > max {
+				t.Fatalf("unexpected reset time (%v): %v < %v <= %v", test.preference, min, got, max)
+			}
+		}
+	}
+}
+
+func TestRecommendedDelay(t *testing.T) {
+	t.Parallel()
+
+	reset := time.Now().Add(100 * time.Second).Truncate(time.Second)
+	newResponse := func(remaining string) *http.Response {
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderXRateLimitRemaining, remaining)
+		header.Set(github_ratelimit.HeaderXRateLimitReset, strconv.FormatInt(reset.Unix(), 10))
+		return &http.Response{StatusCode: http.StatusOK, Header: header}
+	}
+	untilReset := time.Until(reset)
+
+	// exhausted - wait for the reset
+	if got, min, max := github_ratelimit.RecommendedDelay(newResponse("0")), untilReset-time.Second, untilReset; got <= min || got > max {
+		t.Fatalf("unexpected delay: %v < %v <= %v", min, got, max)
+	}
+
+	// plenty of quota - barely wait
+	if got, max := github_ratelimit.RecommendedDelay(newResponse("100000")), time.Millisecond; got > max {
+		t.Fatal(got, max)
+	}
+
+	// mid-range - spread the quota until the reset
+	if got, min, max := github_ratelimit.RecommendedDelay(newResponse("10")), untilReset/10-time.Second/10, untilReset/10; got <= min || got > max {
+		t.Fatalf("unexpected delay: %v < %v <= %v", min, got, max)
+	}
+
+	// no headers
+	if got := github_ratelimit.RecommendedDelay(&http.Response{StatusCode: http.StatusOK, Header: http.Header{}}); got != 0 {
+		t.Fatal(got)
+	}
+}
+
+func TestRetryReusesConnection(t *testing.T) {
+	t.Parallel()
+
+	// a 429 with a retry-after header (detected without reading the body), once
+	var requests atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set(github_ratelimit.HeaderRetryAfter, "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message":"slow down"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	var connections atomic.Int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(server.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatal(got, want)
+	}
+
+	// the limited response was drained and closed before the retry, so the connection is reused
+	if got, want := connections.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestSecondaryLimitAsErrorReleasesConnection(t *testing.T) {
+	t.Parallel()
+	const limitedBody = `slow down`
+
+	// a 429 with a retry-after header (detected without reading the body)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/limited" {
+			w.Header().Set(github_ratelimit.HeaderRetryAfter, "10")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(limitedBody))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	var connections atomic.Int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(server.Client().Transport, github_ratelimit.WithSecondaryLimitAsError())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get(server.URL + "/limited")
+	var limitErr *github_ratelimit.SecondaryRateLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the connection was released (although the body within the error is not closed), so it is reused
+	resp, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// the body of the response within the error is still readable
+	body, err := io.ReadAll(limitErr.Response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(body), limitedBody; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := connections.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -545,6 +549,47 @@ func TestRequestConfigOverride(t *testing.T) {
 
 }
 
+func TestRequestConfigReplace(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	var baseCalls, replacedCalls atomic.Int64
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i,
+		github_ratelimit.WithLimitDetectedCallback(func(*github_ratelimit.CallbackContext) {
+			baseCalls.Add(1)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+
+	// replace the config entirely - the base callback must not be inherited
+	detected := github_ratelimit.WithLimitDetectedCallback(func(*github_ratelimit.CallbackContext) {
+		replacedCalls.Add(1)
+	})
+	ctx := github_ratelimit.WithReplaceConfig(context.Background(), detected)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForNextSleep(i)
+
+	if _, err := c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := baseCalls.Load(), int64(0); got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := replacedCalls.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+}
+
 type orgLister struct {
 }
 
@@ -611,3 +656,1181 @@ func TestGoGithubClientCompatability(t *testing.T) {
 	// - WithSingleSleepLimit(0, ...) => expect AbuseError
 	// - WithSingleSleepLimit(>0, ...) => expect sleeping
 }
+
+func TestRootContextCancel(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 10 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	r, err := github_ratelimit.NewRateLimitWaiterWithContext(ctx, i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// cancel the root context while the request is sleeping
+	go func() {
+		time.Sleep(sleep / 20)
+		cancel()
+	}()
+
+	tBefore := time.Now()
+	_, err = c.Get("/")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, limit := time.Since(tBefore), sleep/2; got >= limit {
+		t.Fatal(got, limit)
+	}
+
+	// the limiter is torn down - new requests fail fast
+	_, err = c.Get("/")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestOperationBudget(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a budget that allows a single sleep
+	ctx := github_ratelimit.WithOperationBudget(context.Background(), sleep+sleep/2)
+	get := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Do(req)
+		return err
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+
+	// first limit - within the budget
+	waitForNextSleep(i)
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+
+	// second limit - the budget is exhausted, so fail fast
+	waitForNextSleep(i)
+	tBefore := time.Now()
+	if err := get(); !errors.Is(err, github_ratelimit.ErrOperationBudgetExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, limit := time.Since(tBefore), sleep/2; got >= limit {
+		t.Fatal(got, limit)
+	}
+
+	// requests outside of the operation are not affected
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOperationBudgetChargesActualWait(t *testing.T) {
+	t.Parallel()
+	const sleep = 1 * time.Second
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(newLimitOnceTransport(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a budget that allows a single sleep
+	ctx := github_ratelimit.WithOperationBudget(context.Background(), sleep+sleep/2)
+	get := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// the sleep is cut short by the request context - only the time actually slept is charged
+	timeoutCtx, cancel := context.WithTimeout(ctx, sleep/5)
+	defer cancel()
+	if err := get(timeoutCtx); err == nil {
+		t.Fatal("expected the sleep to be canceled")
+	}
+
+	// the rest of the limit still fits in the budget
+	if err := get(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallbackAdjustsResetTime(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+	const margin = 2 * time.Second
+
+	callback := func(ctx *github_ratelimit.CallbackContext) {
+		*ctx.SleepUntil = ctx.SleepUntil.Add(margin)
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithLimitDetectedCallback(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - the sleep honors the extended reset time
+	tBefore := time.Now()
+	_, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, min, max := time.Since(tBefore), sleep+margin/2, sleep+margin+sleep; got < min || got > max {
+		t.Fatalf("unexpected sleep duration: %v <= %v <= %v", min, got, max)
+	}
+}
+
+func TestLimitEvents(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	r, err := github_ratelimit.NewRateLimitWaiter(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := r.LimitEvents()
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit
+	_, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func() github_ratelimit.LimitEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(3 * sleep):
+			t.Fatal("timed out waiting for a limit event")
+		}
+		return github_ratelimit.LimitEvent{}
+	}
+
+	active := receive()
+	if !active.Active || active.SleepUntil.IsZero() {
+		t.Fatalf("unexpected event: %v", active)
+	}
+	cleared := receive()
+	if cleared.Active || !cleared.SleepUntil.Equal(active.SleepUntil) {
+		t.Fatalf("unexpected event: %v", cleared)
+	}
+	if time.Now().Before(cleared.SleepUntil) {
+		t.Fatalf("limit cleared too early: %v", cleared.SleepUntil)
+	}
+}
+
+func TestOverrideStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	// a secondary rate limit with an unusual status code, once per path
+	var lock sync.Mutex
+	limited := map[string]bool{}
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if limited[r.URL.Path] {
+			return (&nopServer{}).RoundTrip(r)
+		}
+		limited[r.URL.Path] = true
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		resp := newSecondaryLimitResponse(t, header)
+		resp.StatusCode = http.StatusServiceUnavailable
+		return resp, nil
+	})
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// by default, the status code is not detected
+	resp, err := c.Get("/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Fatal(got, want)
+	}
+	if github_ratelimit.GetLimitDecision(resp).Limited {
+		t.Fatal("unexpected limit detection")
+	}
+
+	// the request with the override detects the limit and retries
+	ctx := github_ratelimit.WithOverrideStatusCodes(context.Background(), http.StatusServiceUnavailable)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/override", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := github_ratelimit.GetLimitDecision(resp); !decision.Limited || decision.Retries != 1 {
+		t.Fatal(decision.Limited, decision.Retries)
+	}
+}
+
+func TestBypass(t *testing.T) {
+	t.Parallel()
+	const sleep = 2 * time.Second
+
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/limit" {
+			header := http.Header{}
+			header.Set(github_ratelimit.HeaderRetryAfter, strconv.Itoa(int(sleep.Seconds())))
+			return newSecondaryLimitResponse(t, header), nil
+		}
+		return (&nopServer{}).RoundTrip(r)
+	})
+	var detected atomic.Int64
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithLimitDetectedCallback(func(*github_ratelimit.CallbackContext) {
+			detected.Add(1)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) (*http.Response, time.Duration) {
+		req, err := http.NewRequestWithContext(github_ratelimit.WithBypass(context.Background()), http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, time.Since(start)
+	}
+
+	// a bypassed request is not retried, but the limit is still detected
+	resp, elapsed := get("/limit")
+	if elapsed > sleep/2 {
+		t.Fatal(elapsed)
+	}
+	if decision := github_ratelimit.GetLimitDecision(resp); !decision.Limited || decision.Retries != 0 {
+		t.Fatal(decision.Limited, decision.Retries)
+	}
+	if got, want := detected.Load(), int64(1); got != want {
+		t.Fatal(got, want)
+	}
+
+	// a bypassed request is not prevented during the active limit
+	resp, elapsed = get("/")
+	if elapsed > sleep/2 {
+		t.Fatal(elapsed)
+	}
+	if decision := github_ratelimit.GetLimitDecision(resp); decision.Limited || decision.Waited != 0 {
+		t.Fatal(decision.Limited, decision.Waited)
+	}
+}
+
+func TestSecondaryStateChangeCallback(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	type transition struct {
+		active    bool
+		resetTime time.Time
+	}
+	transitions := make(chan transition, 2)
+	onStateChange := func(active bool, resetTime time.Time) {
+		transitions <- transition{active, resetTime}
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i,
+		github_ratelimit.WithSecondaryStateChangeCallback(onStateChange))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit
+	_, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func() transition {
+		select {
+		case tr := <-transitions:
+			return tr
+		case <-time.After(3 * sleep):
+			t.Fatal("timed out waiting for a state change")
+		}
+		return transition{}
+	}
+
+	started := receive()
+	if !started.active || started.resetTime.IsZero() {
+		t.Fatalf("unexpected transition: %v", started)
+	}
+	cleared := receive()
+	if cleared.active || !cleared.resetTime.Equal(started.resetTime) {
+		t.Fatalf("unexpected transition: %v", cleared)
+	}
+}
+
+func TestSecondaryStateChangeCallbackOverride(t *testing.T) {
+	t.Parallel()
+	const sleep = 1 * time.Second
+
+	// a secondary rate limit for the first request of each path
+	var paths sync.Map
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if _, limited := paths.LoadOrStore(r.URL.Path, true); limited {
+			return (&nopServer{}).RoundTrip(r)
+		}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, strconv.Itoa(int(sleep.Seconds())))
+		return newSecondaryLimitResponse(t, header), nil
+	})
+
+	var baseCalls atomic.Int64
+	r, err := github_ratelimit.NewRateLimitWaiter(base,
+		github_ratelimit.WithSecondaryStateChangeCallback(func(bool, time.Time) {
+			baseCalls.Add(1)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// the callback is called without holding the lock, so it may use the waiter
+	transitions := make(chan bool, 2)
+	onStateChange := func(active bool, _ time.Time) {
+		r.Pause()
+		r.Resume()
+		transitions <- active
+	}
+	get := func(ctx context.Context, path string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// the callback of the request that triggered the limit is used
+	get(github_ratelimit.WithOverrideConfig(context.Background(), github_ratelimit.WithSecondaryStateChangeCallback(onStateChange)), "/override")
+	for _, want := range []bool{true, false} {
+		select {
+		case got := <-transitions:
+			if got != want {
+				t.Fatal(got, want)
+			}
+		case <-time.After(3 * sleep):
+			t.Fatal("timed out waiting for a state change")
+		}
+	}
+
+	// nothing is inherited by a replaced config
+	get(github_ratelimit.WithReplaceConfig(context.Background()), "/replace")
+	if got, want := baseCalls.Load(), int64(0); got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestSessionRecordReplay(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	// record a short session
+	recorder := github_ratelimit.NewSessionRecorder()
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithSessionRecorder(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit
+	_, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := recorder.Entries()
+	decisions := func(entries []github_ratelimit.SessionEntry) []github_ratelimit.Decision {
+		var decisions []github_ratelimit.Decision
+		for _, entry := range entries {
+			decisions = append(decisions, entry.Decision)
+		}
+		return decisions
+	}
+	want := []github_ratelimit.Decision{
+		github_ratelimit.DecisionPass,
+		github_ratelimit.DecisionRetry,
+		github_ratelimit.DecisionPass,
+	}
+	if got := decisions(recorded); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal(got, want)
+	}
+	if recorded[1].ResetTime == nil || recorded[2].Waited <= 0 {
+		t.Fatalf("unexpected limit entries: %v", recorded[1:])
+	}
+
+	// the session is serializable to a structured sink
+	if _, err := json.Marshal(recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	// replay the session through a new waiter - expect the same decisions
+	replayRecorder := github_ratelimit.NewSessionRecorder()
+	c, err = github_ratelimit.NewRateLimitWaiterClient(github_ratelimit.NewSessionReplayer(recorded),
+		github_ratelimit.WithSessionRecorder(replayRecorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := 0; index < 2; index++ {
+		if _, err := c.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Get("/"); !errors.Is(err, github_ratelimit.ErrSessionExhausted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := decisions(replayRecorder.Entries()); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal(got, want)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	t.Parallel()
+	const pause = 1 * time.Second
+
+	r, err := github_ratelimit.NewRateLimitWaiter(&nopServer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// a paused waiter blocks requests until resumed
+	r.Pause()
+	go func() {
+		time.Sleep(pause)
+		r.Resume()
+	}()
+	tBefore := time.Now()
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, min := time.Since(tBefore), pause; got < min {
+		t.Fatal(got, min)
+	}
+
+	// a paused waiter respects the request context
+	r.Pause()
+	defer r.Resume()
+	ctx, cancel := context.WithTimeout(context.Background(), pause/10)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var waitErr *github_ratelimit.WaitCanceledError
+	if !errors.As(err, &waitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if waitErr.Elapsed <= 0 || waitErr.Remaining != 0 {
+		t.Fatal(waitErr.Elapsed, waitErr.Remaining)
+	}
+}
+
+func TestPrimaryTakesPrecedenceOverSecondary(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	slept := false
+	callback := func(*github_ratelimit.CallbackContext) {
+		slept = true
+	}
+
+	// a single response carrying both a primary and a secondary rate limit
+	i := setupInjecterWithOptions(t, SecondaryRateLimitInjecterOptions{
+		Every:             every,
+		Sleep:             sleep,
+		UseBothRateLimits: true,
+	}, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithLimitDetectedCallback(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - the primary rate limit takes precedence, so the secondary waiter does not sleep
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slept {
+		t.Fatal(slept)
+	}
+	if got, want := resp.Header.Get(github_ratelimit.HeaderXRateLimitRemaining), "0"; got != want {
+		t.Fatal(got, want)
+	}
+	var body github_ratelimit.SecondaryRateLimitBody
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.IsSecondaryRateLimit() {
+		t.Fatalf("expected a secondary rate limit body: %v", body)
+	}
+}
+
+func TestLimitDecision(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	decision := github_ratelimit.GetLimitDecision(resp)
+	if decision == nil || decision.Decision != github_ratelimit.DecisionPass || decision.Limited {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	// attempt during rate limit - slept and retried
+	waitForNextSleep(i)
+	resp, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	decision = github_ratelimit.GetLimitDecision(resp)
+	if decision == nil {
+		t.Fatal("missing decision")
+	}
+	if got, want := decision.Decision, github_ratelimit.DecisionPass; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := decision.Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+	if !decision.Limited || decision.ResetTime == nil || decision.RetryAfter == nil {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+	if got, want := decision.Header, github_ratelimit.HeaderRetryAfter; got != want {
+		t.Fatal(got, want)
+	}
+	if got, min := decision.Waited, sleep/2; got < min {
+		t.Fatal(got, min)
+	}
+}
+
+func TestClientWithTimeout(t *testing.T) {
+	t.Parallel()
+	const every = 5 * time.Second
+	const sleep = 2 * time.Second
+
+	// a sleep within the timeout - slept and retried
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewClientWithTimeout(i, 2*sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+
+	// a sleep above the timeout - the limit is returned without sleeping (rather than timing out)
+	i = setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err = github_ratelimit.NewClientWithTimeout(i, sleep/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+	tBefore := time.Now()
+	resp, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, limit := time.Since(tBefore), sleep/2; got >= limit {
+		t.Fatal(got, limit)
+	}
+	if got, want := github_ratelimit.GetLimitDecision(resp).Decision, github_ratelimit.DecisionReturn; got != want {
+		t.Fatal(got, want)
+	}
+
+	// no timeout - slept and retried
+	i = setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err = github_ratelimit.NewClientWithTimeout(i, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+	resp, err = c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestRetryRewindsBody(t *testing.T) {
+	t.Parallel()
+	const payload = `{"title": "an issue"}`
+
+	// respond with a secondary rate limit to the first attempt, recording the received bodies
+	var lock sync.Mutex
+	var bodies []string
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		bodies = append(bodies, string(body))
+		if len(bodies) > 1 {
+			return (&nopServer{}).RoundTrip(r)
+		}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return newSecondaryLimitResponse(t, header), nil
+	})
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base, github_ratelimit.WithSafeRetryMethods(http.MethodPost))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a rewindable body is resent on retry
+	req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	lock.Lock()
+	if got, want := bodies, []string{payload, payload}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal(got, want)
+	}
+	bodies = nil
+	lock.Unlock()
+
+	// a body that cannot be rewound is not retried
+	req, err = http.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := github_ratelimit.GetLimitDecision(resp).Decision, github_ratelimit.DecisionReturn; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestDebugWriter(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	var output bytes.Buffer
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithDebugWriter(&output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - slept and retried
+	if _, err := c.Get("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []github_ratelimit.DebugEntry
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var entry github_ratelimit.DebugEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("malformed line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	want := []github_ratelimit.Decision{github_ratelimit.DecisionPass, github_ratelimit.DecisionRetry, github_ratelimit.DecisionPass}
+	if got := len(entries); got != len(want) {
+		t.Fatal(got, len(want))
+	}
+	for index, entry := range entries {
+		if got, want := entry.Decision, want[index]; got != want {
+			t.Fatal(index, got, want)
+		}
+	}
+	if entries[1].ResetTime == nil {
+		t.Fatal("missing reset time")
+	}
+	if got, min := entries[2].Waited, sleep/2; got < min {
+		t.Fatal(got, min)
+	}
+}
+
+func TestDetectionWithObserversOnly(t *testing.T) {
+	t.Parallel()
+
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return newSecondaryLimitResponse(t, header), nil
+	})
+
+	// sleeping is disabled and there is no callback, but the debug writer observes the decisions
+	var output bytes.Buffer
+	c, err := github_ratelimit.NewRateLimitWaiterClient(base,
+		github_ratelimit.WithSingleSleepLimit(0, nil),
+		github_ratelimit.WithDebugWriter(&output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !github_ratelimit.GetLimitDecision(resp).Limited {
+		t.Fatal("the limit was not detected")
+	}
+	var entry github_ratelimit.DebugEntry
+	if err := json.Unmarshal(bytes.TrimSpace(output.Bytes()), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entry.Decision, github_ratelimit.DecisionReturn; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestRetryPreservesRequest(t *testing.T) {
+	t.Parallel()
+
+	// a base that mutates the requests it gets, and responds with a secondary rate limit to the first attempt
+	var requests atomic.Int64
+	var lastRequest atomic.Pointer[http.Request]
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r.Header.Set("X-Attempt", fmt.Sprint(requests.Add(1)))
+		r.URL.RawQuery = "mutated=true"
+		lastRequest.Store(r)
+		if requests.Load() > 1 {
+			return (&nopServer{}).RoundTrip(r)
+		}
+		header := http.Header{}
+		header.Set(github_ratelimit.HeaderRetryAfter, "1")
+		return newSecondaryLimitResponse(t, header), nil
+	})
+
+	r, err := github_ratelimit.NewRateLimitWaiter(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://api.github.com/repos", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got, want := requests.Load(), int64(2); got != want {
+		t.Fatal(got, want)
+	}
+	if lastRequest.Load() == req {
+		t.Fatal("the caller's request was sent as-is")
+	}
+	if got := req.Header.Get("X-Attempt"); got != "" {
+		t.Fatalf("the caller's request headers were modified: %v", got)
+	}
+	if got, want := req.URL.String(), "https://api.github.com/repos"; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestSlippedRequests(t *testing.T) {
+	t.Parallel()
+	const every = 5 * time.Second
+	const sleep = 1 * time.Second
+	const parallelReqs = 20
+
+	var callbacks atomic.Int64
+	callback := func(*github_ratelimit.CallbackContext) {
+		callbacks.Add(1)
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	r, err := github_ratelimit.NewRateLimitWaiter(i, github_ratelimit.WithSlipCallback(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{
+		Transport: r,
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// issue concurrent requests at the start of the rate limit - some slip in
+	errChan := make(chan error, parallelReqs)
+	for index := 0; index < parallelReqs; index++ {
+		go func() {
+			_, err := c.Get("/")
+			errChan <- err
+		}()
+	}
+	for index := 0; index < parallelReqs; index++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every abuse attempt seen by the server is a slipped request
+	abuseAttempts := int64(i.(*SecondaryRateLimitInjecter).AbuseAttempts)
+	if abuseAttempts == 0 {
+		t.Fatal("no requests slipped in")
+	}
+	if got, want := r.Stats().Slipped, abuseAttempts; got != want {
+		t.Fatal(got, want)
+	}
+	if got, want := callbacks.Load(), abuseAttempts; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	r, err := github_ratelimit.NewRateLimitWaiter(&nopServer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got, want := recorder.Code, http.StatusOK; got != want {
+		t.Fatal(got, want)
+	}
+	if got := recorder.Header().Get(github_ratelimit.HeaderContentType); !strings.HasPrefix(got, "application/openmetrics-text") {
+		t.Fatal(got)
+	}
+
+	// every line is either a descriptor, a sample, or the terminating EOF
+	descriptor := regexp.MustCompile(`^# (TYPE [a-z_]+ (counter|gauge)|HELP [a-z_]+ .+)$`)
+	sample := regexp.MustCompile(`^[a-z_]+ [0-9]+$`)
+	lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+	for index, line := range lines[:len(lines)-1] {
+		if !descriptor.MatchString(line) && !sample.MatchString(line) {
+			t.Fatalf("malformed line %d: %q", index, line)
+		}
+	}
+	if got, want := lines[len(lines)-1], "# EOF"; got != want {
+		t.Fatal(got, want)
+	}
+	if !strings.Contains(recorder.Body.String(), "\ngithub_ratelimit_slipped_requests_total 0\n") {
+		t.Fatal(recorder.Body.String())
+	}
+}
+
+func TestRequestMetadata(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	var lock sync.Mutex
+	var got map[string]any
+	callback := func(cbContext *github_ratelimit.CallbackContext) {
+		lock.Lock()
+		defer lock.Unlock()
+		got = cbContext.Metadata
+	}
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i, github_ratelimit.WithLimitDetectedCallback(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - the metadata is passed to the callback
+	metadata := map[string]any{"job": "sync-repos", "repo": 42}
+	ctx := github_ratelimit.WithRequestMetadata(context.Background(), metadata)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if fmt.Sprint(got) != fmt.Sprint(metadata) {
+		t.Fatal(got, metadata)
+	}
+}
+
+func TestStandaloneLimiter(t *testing.T) {
+	t.Parallel()
+
+	secondaryBody, err := getSecondaryRateLimitBody("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(secondaryBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limitHeader := http.Header{}
+	limitHeader.Set(github_ratelimit.HeaderRetryAfter, "5")
+
+	l, err := github_ratelimit.NewLimiter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no active limit
+	if got := l.BeforeRequest(); got != 0 {
+		t.Fatal(got)
+	}
+	if got := l.AfterResponse(http.MethodGet, "/repos", http.StatusOK, http.Header{}, nil); got.Limited {
+		t.Fatalf("unexpected action: %+v", got)
+	}
+
+	// a secondary rate limit - wait and retry
+	action := l.AfterResponse(http.MethodGet, "/repos", http.StatusForbidden, limitHeader, body)
+	if !action.Limited || !action.Retry {
+		t.Fatalf("unexpected action: %+v", action)
+	}
+	if got, min, max := action.Wait, 4*time.Second, 5*time.Second; got <= min || got > max {
+		t.Fatalf("unexpected wait: %v < %v <= %v", min, got, max)
+	}
+	if got, max := l.BeforeRequest(), action.Wait; got <= 0 || got > max {
+		t.Fatal(got, max)
+	}
+
+	// a non-idempotent request is not retried
+	if got := l.AfterResponse(http.MethodPost, "/repos", http.StatusForbidden, limitHeader, body); !got.Limited || got.Retry {
+		t.Fatalf("unexpected action: %+v", got)
+	}
+
+	// above the single sleep limit - returned without waiting
+	l, err = github_ratelimit.NewLimiter(github_ratelimit.WithSingleSleepLimit(time.Second, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	action = l.AfterResponse(http.MethodGet, "/repos", http.StatusForbidden, limitHeader, body)
+	if !action.Limited || action.Retry || action.Wait != 0 {
+		t.Fatalf("unexpected action: %+v", action)
+	}
+
+	// the decision options are shared with the waiter
+	l, err = github_ratelimit.NewLimiter(github_ratelimit.WithSecondaryLimitAsError())
+	if err != nil {
+		t.Fatal(err)
+	}
+	action = l.AfterResponse(http.MethodGet, "/repos", http.StatusForbidden, limitHeader, body)
+	var limitErr *github_ratelimit.SecondaryRateLimitError
+	if !action.Limited || action.Retry || !errors.As(action.Err, &limitErr) {
+		t.Fatalf("unexpected action: %+v", action)
+	}
+	l, err = github_ratelimit.NewLimiter(github_ratelimit.WithGlobalMaxRetries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	action = l.AfterResponse(http.MethodGet, "/repos", http.StatusForbidden, limitHeader, body)
+	if !action.Limited || action.Retry || !errors.Is(action.Err, github_ratelimit.ErrGlobalMaxRetriesExceeded) {
+		t.Fatalf("unexpected action: %+v", action)
+	}
+
+	// conflicting options are rejected
+	if _, err := github_ratelimit.NewLimiter(
+		github_ratelimit.WithSecondaryLimitAsError(),
+		github_ratelimit.WithProbeRetry(time.Second, 2),
+	); !errors.Is(err, github_ratelimit.ErrConflictingOptions) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWrapClient(t *testing.T) {
+	t.Parallel()
+	const every = 1 * time.Second
+	const sleep = 1 * time.Second
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c := &http.Client{
+		Transport: i,
+		Timeout:   time.Minute,
+	}
+	if err := github_ratelimit.WrapClient(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the settings are preserved, and the original transport is the base
+	if got, want := c.Timeout, time.Minute; got != want {
+		t.Fatal(got, want)
+	}
+	waiter, ok := c.Transport.(*github_ratelimit.SecondaryRateLimitWaiter)
+	if !ok {
+		t.Fatalf("unexpected transport: %T", c.Transport)
+	}
+	if waiter.Base != i {
+		t.Fatalf("unexpected base: %T", waiter.Base)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// attempt during rate limit - handled by the waiter
+	resp, err := c.Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := github_ratelimit.GetLimitDecision(resp).Retries, 1; got != want {
+		t.Fatal(got, want)
+	}
+}
+
+func TestWaitCanceledError(t *testing.T) {
+	t.Parallel()
+	const every = 5 * time.Second
+	const sleep = 2 * time.Second
+	const cancelAfter = sleep / 4
+
+	i := setupSecondaryLimitInjecter(t, every, sleep, nil)
+	c, err := github_ratelimit.NewRateLimitWaiterClient(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(i)
+
+	// trigger the rate limit, and cancel during the sleep
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(cancelAfter, cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Do(req)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var waitErr *github_ratelimit.WaitCanceledError
+	if !errors.As(err, &waitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, min, max := waitErr.Elapsed, cancelAfter/2, cancelAfter*2; got < min || got > max {
+		t.Fatalf("unexpected elapsed: %v <= %v <= %v", min, got, max)
+	}
+	if got, min, max := waitErr.Remaining, sleep/2, sleep; got < min || got > max {
+		t.Fatalf("unexpected remaining: %v <= %v <= %v", min, got, max)
+	}
+}
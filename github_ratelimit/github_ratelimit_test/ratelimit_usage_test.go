@@ -0,0 +1,50 @@
+package github_ratelimit_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofri/go-github-ratelimit/github_ratelimit"
+)
+
+// Example_deterministicOrdering shows how to make the waiter reproducible in unit tests.
+func Example_deterministicOrdering() {
+	injecter, err := NewRateLimitInjecter(&nopServer{}, &SecondaryRateLimitInjecterOptions{
+		Every: 1 * time.Second,
+		Sleep: 1 * time.Second,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := github_ratelimit.NewRateLimitWaiterClient(injecter, github_ratelimit.WithDeterministicOrdering())
+	if err != nil {
+		panic(err)
+	}
+
+	// initialize injecter timing
+	_, _ = c.Get("/")
+	waitForNextSleep(injecter)
+
+	// concurrent requests during the rate limit are serialized, so none of them slips in
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get("/")
+			if err != nil {
+				panic(err)
+			}
+			if resp.StatusCode == http.StatusForbidden {
+				panic("unexpected rate limit response")
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Println("abuse attempts:", injecter.(*SecondaryRateLimitInjecter).AbuseAttempts)
+	// Output: abuse attempts: 0
+}
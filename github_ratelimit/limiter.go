@@ -0,0 +1,90 @@
+package github_ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// LimitAction is the action to take regarding a request, as decided by the Limiter.
+type LimitAction struct {
+	// Limited is true if the response is a secondary rate limit.
+	Limited bool
+	// Retry is true if the request should be retried after waiting (false means the response should be returned as-is).
+	Retry bool
+	// Wait is the duration to wait before sending the next request.
+	Wait time.Duration
+	// Err is the error to report instead of the response, if any
+	// (e.g., a SecondaryRateLimitError with WithSecondaryLimitAsError, or ErrGlobalMaxRetriesExceeded).
+	Err error
+}
+
+// Limiter is a transport-agnostic secondary rate limit state machine,
+// for users that manage their own HTTP layer (i.e., without an http.RoundTripper).
+// It shares the detection and the decision logic (and the options) with the SecondaryRateLimitWaiter
+// (including the decision recording, e.g., WithDebugWriter, and the slip counting):
+// call BeforeRequest before sending each request, and AfterResponse once its response arrives.
+// Note: options that depend on the transport (e.g., probing, coalescing and concurrency limits) have no effect.
+type Limiter struct {
+	waiter *SecondaryRateLimitWaiter
+}
+
+// NewLimiter creates a standalone limiter with the given options.
+// Returns an error if the options contradict each other (see Validate).
+func NewLimiter(opts ...Option) (*Limiter, error) {
+	config := newConfig(opts...)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	waiter := &SecondaryRateLimitWaiter{
+		ctx:    context.Background(),
+		events: make(chan LimitEvent, limitEventsBufferSize),
+	}
+	waiter.config.Store(config)
+	return &Limiter{
+		waiter: waiter,
+	}, nil
+}
+
+// BeforeRequest returns the duration to wait before sending a request (zero if there is no active limit).
+func (l *Limiter) BeforeRequest() time.Duration {
+	l.waiter.lock.RLock()
+	defer l.waiter.lock.RUnlock()
+
+	if sleepDuration := l.waiter.currentSleepDurationUnlocked(); sleepDuration > 0 {
+		return sleepDuration
+	}
+	return 0
+}
+
+// AfterResponse updates the state with the response of a request, and returns the action to take regarding it.
+// The method and the path identify the request (for the request filter, the retry decider and the safe retry methods),
+// and the status code, the headers and the body are those of the response.
+func (l *Limiter) AfterResponse(method string, path string, statusCode int, header http.Header, body []byte) LimitAction {
+	request := &http.Request{
+		Method: method,
+		URL:    &url.URL{Path: path},
+		Header: http.Header{},
+	}
+	resp := &http.Response{
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+
+	config := l.waiter.config.Load()
+	secondaryLimit, _ := l.waiter.detectSecondaryLimit(config, request, resp)
+	_, retryRequest, err := l.waiter.handleResponse(config, request, resp, secondaryLimit, false, &LimitDecision{}, 0)
+	return LimitAction{
+		Limited: secondaryLimit != nil,
+		Retry:   retryRequest != nil,
+		Wait:    l.BeforeRequest(),
+		Err:     err,
+	}
+}
@@ -0,0 +1,30 @@
+package github_ratelimit
+
+import (
+	"context"
+	"net/http"
+)
+
+type requestMetadataKey struct{}
+
+// WithRequestMetadata adds arbitrary metadata (e.g., a job ID) to the context of a request.
+// The metadata is passed to the callbacks (see CallbackContext.Metadata), for debugging.
+func WithRequestMetadata(ctx context.Context, metadata map[string]any) context.Context {
+	return context.WithValue(ctx, requestMetadataKey{}, metadata)
+}
+
+// GetRequestMetadata returns the metadata in the context, or nil if there is none (see WithRequestMetadata).
+func GetRequestMetadata(ctx context.Context) map[string]any {
+	metadata, _ := ctx.Value(requestMetadataKey{}).(map[string]any)
+	return metadata
+}
+
+// newCallbackContext creates the callback context for the request and its response.
+func (t *SecondaryRateLimitWaiter) newCallbackContext(request *http.Request, resp *http.Response) *CallbackContext {
+	return &CallbackContext{
+		RoundTripper: t,
+		Request:      request,
+		Response:     resp,
+		Metadata:     GetRequestMetadata(request.Context()),
+	}
+}
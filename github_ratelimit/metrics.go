@@ -0,0 +1,35 @@
+package github_ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// metricsContentType is the content type of the OpenMetrics text format.
+const metricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// MetricsHandler returns an HTTP handler that renders the counters of the waiter (see Stats)
+// in the OpenMetrics text format, e.g., to be served as /metrics and scraped by Prometheus.
+// It allows exposing the counters without depending on a metrics library.
+func (t *SecondaryRateLimitWaiter) MetricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(HeaderContentType, metricsContentType)
+		_, _ = w.Write([]byte(renderMetrics(t.Stats())))
+	}
+}
+
+// renderMetrics renders the stats in the OpenMetrics text format.
+func renderMetrics(stats Stats) string {
+	var b strings.Builder
+	writeCounter(&b, "github_ratelimit_slipped_requests", "Requests that got a secondary rate limit response while a limit was already active.", stats.Slipped)
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// writeCounter writes a counter metric family (with a single sample) in the OpenMetrics text format.
+func writeCounter(b *strings.Builder, name string, help string, value int64) {
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "%s_total %d\n", name, value)
+}
@@ -1,6 +1,7 @@
 package github_ratelimit
 
 import (
+	"io"
 	"time"
 )
 
@@ -30,3 +31,334 @@ func WithTotalSleepLimit(limit time.Duration, callback OnTotalLimitExceeded) Opt
 		c.onTotalLimitExceeded = callback
 	}
 }
+
+// WithSleepBudgetPerWindow limits the accumulated duration allowed to sleep within a rolling time window
+// (e.g., no more than 5 minutes of sleep per hour), as opposed to the lifetime limit of WithTotalSleepLimit.
+// Sleeps age out of the budget once they are older than the window.
+// When the budget is exhausted, the response is returned without sleeping. The callback parameter is nillable.
+func WithSleepBudgetPerWindow(budget time.Duration, window time.Duration, callback OnWindowBudgetExceeded) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.windowSleepBudget = &budget
+		c.sleepBudgetWindow = window
+		c.onWindowBudgetExceeded = callback
+	}
+}
+
+// WithRecoveryCallback adds a callback to be called when a retried request finally succeeds (e.g., for recovery time metrics).
+func WithRecoveryCallback(callback OnRecovered) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.onRecovered = callback
+	}
+}
+
+// WithSlipCallback adds a callback to be called when a request slipped through during an active limit (see Stats).
+func WithSlipCallback(callback OnSlipped) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.onSlipped = callback
+	}
+}
+
+// WithResponseInspector adds a callback to be called on every response before the limiter decides anything.
+// Useful for telemetry and custom header extraction. The inspector must not mutate the response body.
+func WithResponseInspector(inspector ResponseInspector) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.responseInspector = inspector
+	}
+}
+
+// ResetPreference decides which header to use when both retry-after and x-ratelimit-reset are present.
+type ResetPreference int
+
+const (
+	// ResetPreferenceRetryAfterFirst uses retry-after (the default).
+	ResetPreferenceRetryAfterFirst ResetPreference = iota
+	// ResetPreferenceResetFirst uses x-ratelimit-reset.
+	ResetPreferenceResetFirst
+	// ResetPreferenceMaxOf uses the later of the two (safer).
+	ResetPreferenceMaxOf
+	// ResetPreferenceMinOf uses the earlier of the two.
+	ResetPreferenceMinOf
+)
+
+// WithPreciseSleep uses the exact sleep durations instead of rounding them up to whole seconds
+// (i.e., for the total sleep time, as accounted by WithTotalSleepLimit and reported to the callbacks).
+// Useful for proxies and test setups that provide sub-second accurate reset times.
+// Note: unsafe with GitHub itself, which uses second-granularity reset times.
+func WithPreciseSleep() Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.preciseSleep = true
+	}
+}
+
+// WithOscillationGuard extends the sleeps of repeated secondary rate limits, to break an oscillation
+// between brief sleeps and immediate re-limits. Once n limits are detected within a rolling minute,
+// the sleep is multiplied by the multiplier (compounded for every additional limit), up to maxSleep.
+// Sleeps that are longer than maxSleep to begin with are never shortened.
+func WithOscillationGuard(n int, multiplier float64, maxSleep time.Duration) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.oscillationThreshold = n
+		c.oscillationMultiplier = multiplier
+		c.oscillationMaxSleep = maxSleep
+	}
+}
+
+// WithResetPreference sets the header to use when both retry-after and x-ratelimit-reset are present and disagree.
+func WithResetPreference(preference ResetPreference) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.resetPreference = preference
+	}
+}
+
+// WithLenientDetection treats any 403/429 response with a positive retry-after header as a secondary rate limit,
+// regardless of its body (by default, a 403 body must match a secondary rate limit).
+// Useful for endpoints that return 403 with retry-after for reasons adjacent to rate limiting (e.g., large uploads).
+// The tradeoff: such responses are waited for and retried, even if waiting would not help.
+func WithLenientDetection() Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.lenientDetection = true
+	}
+}
+
+// WithServiceUnavailableBackoff retries requests that got a 503 (service unavailable) response with a retry-after header,
+// after waiting for the duration it specifies (e.g., during brief GitHub incidents), up to maxRetries times per request.
+// The back off applies only to the request itself (other requests are not paused), and is limited by the single sleep limit.
+// The callback is triggered before backing off, separately from the secondary rate limit callbacks.
+func WithServiceUnavailableBackoff(maxRetries int, callback OnServiceUnavailable) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.serviceUnavailableMaxRetries = maxRetries
+		c.onServiceUnavailable = callback
+	}
+}
+
+// WithDetectionStatusCodes sets the status codes of the responses that are checked for a secondary rate limit,
+// replacing the default ones (403 and 429), e.g., to detect limits returned by a proxy with a 503.
+// The responses are still validated (see WithLenientDetection). Use WithOverrideStatusCodes to set them per-request.
+func WithDetectionStatusCodes(statusCodes ...int) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.statusCodes = statusCodes
+	}
+}
+
+// WithSecondaryRateLimitMessages adds message prefixes that identify a secondary rate limit,
+// e.g., for GitHub Enterprise servers that are configured with localized error messages.
+// Note: the documentation URL is checked regardless of the message, so the prefixes are only needed
+// when the URL is missing or modified as well.
+func WithSecondaryRateLimitMessages(prefixes ...string) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.messages = append(c.messages[:len(c.messages):len(c.messages)], prefixes...)
+	}
+}
+
+// WithSecondaryLimitAsError returns a SecondaryRateLimitError instead of sleeping when a secondary rate limit is detected.
+// The error carries the reset time, so that the caller can orchestrate the waiting.
+func WithSecondaryLimitAsError() Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.limitAsError = true
+	}
+}
+
+// WithMinSleep sets a floor for the sleep duration of a detected secondary rate limit,
+// to smooth tight retry loops against tiny retry-after values. The default is 0 (no floor).
+func WithMinSleep(minSleep time.Duration) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.minSleep = minSleep
+	}
+}
+
+// DefaultMaxResetHorizon is the default max reset horizon (see WithMaxResetHorizon).
+const DefaultMaxResetHorizon = 24 * time.Hour
+
+// WithMaxResetHorizon sets a cap for the end of a detected secondary rate limit, as a safety net against absurd reset values
+// (e.g., due to a bug or a misbehaving proxy) that would otherwise stall the client indefinitely.
+// Reset times beyond the horizon are clamped to it. The default is DefaultMaxResetHorizon; non-positive values disable the cap.
+func WithMaxResetHorizon(horizon time.Duration) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.maxResetHorizon = &horizon
+	}
+}
+
+// DefaultMillisecondResetThreshold is the default millisecond reset threshold (see WithMillisecondResetThreshold).
+// As seconds since epoch, it is in the year 5138; as milliseconds, it is in 1973 - so either form is unambiguous.
+const DefaultMillisecondResetThreshold = 100_000_000_000
+
+// WithMillisecondResetThreshold sets the x-ratelimit-reset value above which it is interpreted as milliseconds since epoch
+// instead of seconds (as emitted by some proxies), since it would otherwise pin the client for millennia.
+// The default is DefaultMillisecondResetThreshold; non-positive values disable the heuristic.
+func WithMillisecondResetThreshold(threshold int64) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.millisecondResetThreshold = &threshold
+	}
+}
+
+// WithGlobalMaxRetries limits the number of retries due to secondary rate limits across all requests.
+// Once exceeded, further detected limits fail with ErrGlobalMaxRetriesExceeded instead of retrying.
+// Use ResetGlobalRetries to reset the counter.
+func WithGlobalMaxRetries(maxRetries int64) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.globalMaxRetries = &maxRetries
+	}
+}
+
+// WithResponseModifier adds a callback to modify the response right before it is returned,
+// e.g., to strip or normalize the rate limit headers for downstream consumers.
+// It is called once per request (for the returned response only), and must keep the body readable.
+func WithResponseModifier(modifier ResponseModifier) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.responseModifier = modifier
+	}
+}
+
+// WithRequestFilter adds a filter to decide per-request whether the secondary rate limit detection applies.
+// When the filter returns false, the response is returned untouched (its body is never read).
+func WithRequestFilter(filter RequestFilter) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.requestFilter = filter
+	}
+}
+
+// WithRetryDecider adds a callback to decide whether to retry a request that got a secondary rate limit response.
+// When the decider returns false, the response is returned instead of retrying (the limit is still respected by other requests).
+func WithRetryDecider(decider RetryDecider) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.retryDecider = decider
+	}
+}
+
+// WithSafeRetryMethods sets the HTTP methods that are retried after a secondary rate limit (GET, HEAD and OPTIONS by default).
+// Requests with other methods get the secondary rate limit response instead of being retried,
+// since replaying a non-idempotent request (e.g., POST) may cause duplicate side effects
+// in case the original request was processed nonetheless.
+// Use with caution to add non-idempotent methods (e.g., for endpoints that are known to be idempotent).
+func WithSafeRetryMethods(methods ...string) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.safeRetryMethods = methods
+	}
+}
+
+// WithPreSendCallback adds a callback to be called right before every request is sent, reporting how long it waited.
+// Useful for attributing latency to the waiter without wrapping the transport.
+func WithPreSendCallback(callback PreSendCallback) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.preSendCallback = callback
+	}
+}
+
+// WithProbeRetry enables probing an active rate limit in order to detect an early reset.
+// During the limit, a single waiting request is issued as a probe every interval,
+// and the interval is multiplied by the backoff factor after each probe (factors below 1 are treated as 1).
+// A successful probe releases the limit for all waiting requests.
+func WithProbeRetry(interval time.Duration, backoffFactor float64) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.probeInterval = interval
+		c.probeBackoffFactor = backoffFactor
+	}
+}
+
+// WithRestPointWindow paces the requests to avoid the secondary rate limit in the first place.
+// The REST points of the requests are estimated per GitHub API docs (RestReadPoints for GET/HEAD/OPTIONS, RestWritePoints otherwise),
+// and requests block (context-aware) while they would exceed RestPointsPerMinute within a sliding window of a minute.
+func WithRestPointWindow() Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.restPointWindow = true
+	}
+}
+
+// WithRequestsPerMinute paces the requests to a flat number of requests per minute, regardless of their cost,
+// as a simpler alternative to WithRestPointWindow.
+// Requests block (context-aware) while they would exceed the limit within a sliding window of a minute.
+// Non-positive values disable the pacing.
+func WithRequestsPerMinute(rpm int) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.requestsPerMinute = rpm
+	}
+}
+
+// WithThrottleKey tracks the REST point window (see WithRestPointWindow) and the requests window (see WithRequestsPerMinute) per key, as returned by the given function,
+// e.g., to throttle each installation or organization by its own budget.
+// By default, all requests share a single window.
+// Note: a window is kept per key, so the keys should be of a bounded number.
+func WithThrottleKey(key ThrottleKey) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.throttleKey = key
+	}
+}
+
+// WithFairRelease makes the requests that wait for a secondary rate limit resume in arrival order,
+// spaced apart by the given duration once the limit is over, to avoid a burst of requests right after the reset.
+// Requests that arrive before all the waiting requests resume queue up behind them.
+func WithFairRelease(spacing time.Duration) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.fairReleaseSpacing = spacing
+	}
+}
+
+// WithCoalescing makes the requests that resume after a secondary rate limit share a single retry:
+// a single request (the leader) is issued once the limit is over, while the others wait for it to complete.
+// If the leader is limited again, the others keep waiting, so fewer requests slip in during an extended limit.
+func WithCoalescing() Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.coalescing = true
+	}
+}
+
+// WithHardConcurrencyLimit limits the number of requests that are in-flight at the same time.
+// The slots are acquired only after waiting for any active secondary rate limit (and released once the response arrives),
+// so requests that wait for a limit to pass do not hold a slot that cannot be used.
+// Note: the limit is set for the waiter, so per-request overrides of it have no effect.
+func WithHardConcurrencyLimit(limit int) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.concurrencyLimit = limit
+	}
+}
+
+// WithDeterministicOrdering serializes the requests that pass through the waiter,
+// so that no request slips in during a secondary rate limit (i.e., the behavior is reproducible).
+// Note: meant for testing only - it completely eliminates the concurrency of the client.
+func WithDeterministicOrdering() Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.deterministicOrdering = true
+	}
+}
+
+// WithSessionRecorder records every response and the decision of the waiter regarding it, for debugging.
+// Note: the recorder buffers all response bodies - avoid it in production.
+func WithSessionRecorder(recorder *SessionRecorder) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.sessionRecorder = recorder
+	}
+}
+
+// WithSecondaryStateChangeCallback adds a callback to be called whenever a secondary rate limit becomes active or clears.
+// The callback of the request that triggered the transition is used, so it may be overridden per-request
+// (see WithOverrideConfig), and the clearance of a limit is reported to the callback that reported its activation.
+func WithSecondaryStateChangeCallback(callback OnSecondaryStateChange) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.onStateChange = callback
+	}
+}
+
+// WithBatchedEvents delivers the limit events (see LimitEvents) to the handler in batches,
+// at every interval or once maxBatch events are buffered (non-positive values disable either trigger).
+// This reduces the callback overhead for very high throughput.
+// Call Close on the waiter to flush the remaining events and stop the batching.
+// Note: the batching is set for the waiter, so per-request overrides of it have no effect.
+func WithBatchedEvents(interval time.Duration, maxBatch int, handler LimitEventsHandler) Option {
+	return func(c *SecondaryRateLimitConfig) {
+		c.batchInterval = interval
+		c.batchMax = maxBatch
+		c.batchHandler = handler
+	}
+}
+
+// WithDebugWriter writes a JSON line (see DebugEntry) per decision of the waiter regarding a response, for debugging.
+// Unlike WithSessionRecorder, response bodies are not buffered, so it is fit for capturing the behavior in production.
+// The writes are serialized, and write errors are ignored.
+func WithDebugWriter(w io.Writer) Option {
+	var debugWriter *debugWriter
+	if w != nil {
+		debugWriter = newDebugWriter(w)
+	}
+	return func(c *SecondaryRateLimitConfig) {
+		c.debugWriter = debugWriter
+	}
+}
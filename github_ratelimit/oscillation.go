@@ -0,0 +1,48 @@
+package github_ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// oscillationWindow is the rolling window in which repeated secondary rate limits are considered an oscillation
+// (see WithOscillationGuard).
+const oscillationWindow = time.Minute
+
+// oscillationGuard extends the sleeps of repeated secondary rate limits (see WithOscillationGuard).
+type oscillationGuard struct {
+	limits []time.Time
+}
+
+// extendUnlocked records a new secondary rate limit and returns its (possibly extended) sleep duration.
+// Note: expects the lock to be held.
+func (g *oscillationGuard) extendUnlocked(config *SecondaryRateLimitConfig, sleepDuration time.Duration) time.Duration {
+	if config.oscillationThreshold <= 0 {
+		return sleepDuration
+	}
+
+	// keep only the limits within the rolling window
+	now := time.Now()
+	recent := g.limits[:0]
+	for _, limit := range g.limits {
+		if now.Sub(limit) < oscillationWindow {
+			recent = append(recent, limit)
+		}
+	}
+	g.limits = append(recent, now)
+
+	count := len(g.limits)
+	if count < config.oscillationThreshold {
+		return sleepDuration
+	}
+
+	factor := math.Pow(config.oscillationMultiplier, float64(count-config.oscillationThreshold+1))
+	extended := time.Duration(float64(sleepDuration) * factor)
+	if extended > config.oscillationMaxSleep {
+		extended = config.oscillationMaxSleep
+	}
+	if extended < sleepDuration {
+		return sleepDuration
+	}
+	return extended
+}
@@ -0,0 +1,35 @@
+package github_ratelimit
+
+import (
+	"net/http"
+	"time"
+)
+
+// RecommendedDelay returns how long to wait before the next request, so that the remaining (primary) rate limit quota
+// is spread evenly until the reset, per the x-ratelimit-remaining and x-ratelimit-reset headers of the response.
+// Once the quota is exhausted, it returns the time until the reset.
+// It returns 0 if the headers are missing or the reset time has passed.
+// It allows implementing custom pacing, regardless of the waiter.
+func RecommendedDelay(resp *http.Response) time.Duration {
+	if resp == nil || resp.Header == nil {
+		return 0
+	}
+
+	remaining, ok := httpResponseIntValue(resp, HeaderXRateLimitRemaining)
+	if !ok || remaining < 0 {
+		return 0
+	}
+	reset := parseXRateLimitReset(resp, DefaultMillisecondResetThreshold)
+	if reset == nil {
+		return 0
+	}
+
+	untilReset := time.Until(*reset)
+	if untilReset <= 0 {
+		return 0
+	}
+	if remaining == 0 {
+		return untilReset
+	}
+	return untilReset / time.Duration(remaining)
+}
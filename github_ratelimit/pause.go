@@ -0,0 +1,52 @@
+package github_ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Pause pauses all outgoing requests until Resume is called, e.g., for maintenance windows.
+// New requests block (context-aware) while the waiter is paused.
+// This is independent of the rate limit handling.
+func (t *SecondaryRateLimitWaiter) Pause() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.paused == nil {
+		t.paused = make(chan struct{})
+	}
+}
+
+// Resume resumes the outgoing requests after a Pause.
+func (t *SecondaryRateLimitWaiter) Resume() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.paused != nil {
+		close(t.paused)
+		t.paused = nil
+	}
+}
+
+// waitForResume waits for the waiter to be resumed if it is paused.
+// returns a WaitCanceledError if either the request context or the root context is done
+// (without a remaining duration, since the end of a pause is unknown).
+func (t *SecondaryRateLimitWaiter) waitForResume(ctx context.Context) error {
+	t.lock.RLock()
+	paused := t.paused
+	t.lock.RUnlock()
+
+	if paused == nil {
+		return nil
+	}
+
+	start := time.Now()
+	select {
+	case <-paused:
+		return nil
+	case <-ctx.Done():
+		return newWaitCanceledError(ctx.Err(), start, 0)
+	case <-t.ctx.Done():
+		return newWaitCanceledError(t.ctx.Err(), start, 0)
+	}
+}
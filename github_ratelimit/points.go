@@ -0,0 +1,153 @@
+package github_ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// REST API point costs for the secondary rate limit.
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api#calculating-points-for-the-secondary-rate-limit
+const (
+	RestPointsPerMinute = 900
+	RestReadPoints      = 1
+	RestWritePoints     = 5
+)
+
+const restPointWindow = time.Minute
+
+// requestPoints estimates the secondary rate limit points of a REST request.
+func requestPoints(request *http.Request) int {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RestReadPoints
+	default:
+		return RestWritePoints
+	}
+}
+
+type pointEntry struct {
+	at     time.Time
+	points int
+}
+
+// pointWindow is a sliding window counter of the points consumed by requests.
+type pointWindow struct {
+	lock    sync.Mutex
+	entries []*pointEntry
+	total   int
+}
+
+// throttleKeyOf returns the throttle key of the request (see WithThrottleKey).
+func (c *SecondaryRateLimitConfig) throttleKeyOf(request *http.Request) string {
+	if c.throttleKey == nil {
+		return ""
+	}
+	return c.throttleKey(request)
+}
+
+// pointWindows holds a point window per throttle key (see WithThrottleKey).
+type pointWindows struct {
+	lock    sync.Mutex
+	windows map[string]*pointWindow
+}
+
+// get returns the point window of the key, creating it if needed.
+func (w *pointWindows) get(key string) *pointWindow {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.windows == nil {
+		w.windows = make(map[string]*pointWindow)
+	}
+	window, ok := w.windows[key]
+	if !ok {
+		window = &pointWindow{}
+		w.windows[key] = window
+	}
+	return window
+}
+
+// reserve reserves the points in the window, and returns the reserved entry.
+// returns the duration to wait before trying again if the window is full (in which case nothing is reserved).
+func (w *pointWindow) reserve(points int, limit int, window time.Duration) (*pointEntry, time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := time.Now()
+
+	// evict the entries that slid out of the window
+	for len(w.entries) > 0 && !w.entries[0].at.Add(window).After(now) {
+		w.total -= w.entries[0].points
+		w.entries = w.entries[1:]
+	}
+
+	// an empty window always fits (even if the points are above the limit)
+	if w.total+points <= limit || len(w.entries) == 0 {
+		entry := &pointEntry{at: now, points: points}
+		w.entries = append(w.entries, entry)
+		w.total += points
+		return entry, 0
+	}
+
+	// wait for enough entries to slide out of the window
+	freed := 0
+	for _, entry := range w.entries {
+		freed += entry.points
+		if w.total-freed+points <= limit {
+			return nil, entry.at.Add(window).Sub(now)
+		}
+	}
+	return nil, window
+}
+
+// unreserve releases a reserved entry, e.g., if the request is not sent after all.
+func (w *pointWindow) unreserve(entry *pointEntry) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for index, reserved := range w.entries {
+		if reserved == entry {
+			w.entries = append(w.entries[:index], w.entries[index+1:]...)
+			w.total -= entry.points
+			return
+		}
+	}
+}
+
+// waitForPointWindow waits until the request fits in the REST point window of its key (see WithRestPointWindow).
+// returns a function that releases the reserved points (a no-op if there is no point window).
+func (t *SecondaryRateLimitWaiter) waitForPointWindow(ctx context.Context, config *SecondaryRateLimitConfig, request *http.Request) (func(), error) {
+	if !config.restPointWindow {
+		return func() {}, nil
+	}
+
+	window := t.points.get(config.throttleKeyOf(request))
+	return t.waitForWindow(ctx, window, requestPoints(request), RestPointsPerMinute)
+}
+
+// waitForRequestWindow waits until the request fits in the requests per minute window of its key (see WithRequestsPerMinute).
+// returns a function that releases the reserved request (a no-op if there is no requests per minute window).
+func (t *SecondaryRateLimitWaiter) waitForRequestWindow(ctx context.Context, config *SecondaryRateLimitConfig, request *http.Request) (func(), error) {
+	if config.requestsPerMinute <= 0 {
+		return func() {}, nil
+	}
+
+	window := t.requests.get(config.throttleKeyOf(request))
+	return t.waitForWindow(ctx, window, 1, config.requestsPerMinute)
+}
+
+// waitForWindow waits until the points fit in the window (of a minute), and reserves them.
+// returns a function that releases the reserved points.
+func (t *SecondaryRateLimitWaiter) waitForWindow(ctx context.Context, window *pointWindow, points int, limit int) (func(), error) {
+	for {
+		entry, wait := window.reserve(points, limit, restPointWindow)
+		if entry != nil {
+			return func() { window.unreserve(entry) }, nil
+		}
+		if err := t.sleepWithContext(ctx, wait, nil); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package github_ratelimit
+
+import (
+	"time"
+)
+
+// probeState tracks the probing of an active secondary rate limit (see WithProbeRetry).
+type probeState struct {
+	interval time.Duration
+	factor   float64
+	next     time.Time
+	inFlight bool
+}
+
+// newProbeState creates the probing state for a new rate limit.
+// returns nil if probing is not configured.
+func newProbeState(config *SecondaryRateLimitConfig) *probeState {
+	if config.probeInterval <= 0 {
+		return nil
+	}
+
+	factor := config.probeBackoffFactor
+	if factor < 1 {
+		factor = 1
+	}
+
+	return &probeState{
+		interval: config.probeInterval,
+		factor:   factor,
+		next:     time.Now().Add(config.probeInterval),
+	}
+}
+
+// claimProbe attempts to claim the next probe of the active rate limit.
+// returns the probe state if the caller should issue its request as a probe,
+// or otherwise, the duration to sleep before checking again.
+func (t *SecondaryRateLimitWaiter) claimProbe() (*probeState, time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	sleepDuration := t.currentSleepDurationUnlocked()
+	if sleepDuration <= 0 || t.probe == nil {
+		return nil, sleepDuration
+	}
+
+	untilProbe := time.Until(t.probe.next)
+	if untilProbe <= 0 && !t.probe.inFlight {
+		// back off before the next probe
+		t.probe.inFlight = true
+		t.probe.interval = time.Duration(float64(t.probe.interval) * t.probe.factor)
+		t.probe.next = time.Now().Add(t.probe.interval)
+		return t.probe, 0
+	}
+
+	// the probe is in-flight - check again after an interval
+	if untilProbe <= 0 {
+		untilProbe = t.probe.interval
+	}
+	if untilProbe < sleepDuration {
+		return nil, untilProbe
+	}
+	return nil, sleepDuration
+}
+
+// finishProbe completes the in-flight probe (nil for requests that are not probes).
+// if the probe was not limited, the active rate limit is released for all waiting requests.
+// the config is the one of the probe request (see WithSecondaryStateChangeCallback).
+func (t *SecondaryRateLimitWaiter) finishProbe(config *SecondaryRateLimitConfig, probe *probeState, released bool) {
+	if probe == nil {
+		return
+	}
+
+	// the state change callback is triggered once the lock is released
+	var stateChange *LimitEvent
+	defer func() {
+		notifyStateChange(config, stateChange)
+	}()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// the probed rate limit is no longer the active one
+	if t.probe != probe {
+		return
+	}
+	t.probe.inFlight = false
+
+	if !released {
+		return
+	}
+
+	stateChange = &LimitEvent{Active: false, SleepUntil: *t.sleepUntil}
+	t.emitEvent(*stateChange)
+	t.sleepUntil = nil
+	t.probe = nil
+	close(t.released)
+	t.released = nil
+}
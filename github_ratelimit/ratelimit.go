@@ -1,9 +1,13 @@
 package github_ratelimit
 
 import (
+	"context"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,18 +16,51 @@ type SecondaryRateLimitWaiter struct {
 	sleepUntil     *time.Time
 	lock           sync.RWMutex
 	totalSleepTime time.Duration
-	config         *SecondaryRateLimitConfig
+	config         atomic.Pointer[SecondaryRateLimitConfig]
+	configLock     sync.Mutex
+	ctx            context.Context
+	retries        atomic.Int64
+	probe          *probeState
+	released       chan struct{}
+	serial         sync.Mutex
+	events         chan LimitEvent
+	eventsEnabled  atomic.Bool
+	points         pointWindows
+	requests       pointWindows
+	paused         chan struct{}
+	coalesce       *coalesceGate
+	slots          chan struct{}
+	slipped        atomic.Int64
+	batcher        *eventBatcher
+	oscillation    oscillationGuard
+	sleepWindow    sleepWindow
+	fair           fairQueue
 }
 
 func NewRateLimitWaiter(base http.RoundTripper, opts ...Option) (*SecondaryRateLimitWaiter, error) {
+	return NewRateLimitWaiterWithContext(context.Background(), base, opts...)
+}
+
+// NewRateLimitWaiterWithContext creates a waiter that is bound to the given (root) context.
+// Once the context is done, in-flight sleeps are aborted and new requests fail with ctx.Err().
+// This complements the per-request contexts with a global kill switch.
+func NewRateLimitWaiterWithContext(ctx context.Context, base http.RoundTripper, opts ...Option) (*SecondaryRateLimitWaiter, error) {
 	if base == nil {
 		base = http.DefaultTransport
 	}
 
+	config := newConfig(opts...)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	waiter := SecondaryRateLimitWaiter{
 		Base:   base,
-		config: newConfig(opts...),
+		ctx:    ctx,
+		events: make(chan LimitEvent, limitEventsBufferSize),
+		slots:  newConcurrencySlots(config),
 	}
+	waiter.config.Store(config)
+	waiter.batcher = newEventBatcher(ctx, config)
 
 	return &waiter, nil
 }
@@ -39,6 +76,38 @@ func NewRateLimitWaiterClient(base http.RoundTripper, opts ...Option) (*http.Cli
 	}, nil
 }
 
+// WrapClient wraps the transport of an existing client with a waiter, in place.
+// The existing transport (or http.DefaultTransport if nil) is used as the base of the waiter,
+// and the other settings of the client (e.g., timeout, cookie jar and redirect policy) are preserved.
+func WrapClient(client *http.Client, opts ...Option) error {
+	waiter, err := NewRateLimitWaiter(client.Transport, opts...)
+	if err != nil {
+		return err
+	}
+
+	client.Transport = waiter
+	return nil
+}
+
+// NewClientWithTimeout creates a client with the given timeout (see http.Client.Timeout).
+// The timeout covers the whole request, including the secondary rate limit sleeps.
+// Therefore, sleeps that are longer than the timeout are not attempted (as they could never complete in time),
+// and the secondary rate limit response is returned instead (using WithSingleSleepLimit, which the options may override).
+// Shorter sleeps that exceed the time left for the request fail with the client's timeout error.
+// A non-positive timeout means no timeout (as for http.Client), so the sleeps are not limited.
+func NewClientWithTimeout(base http.RoundTripper, timeout time.Duration, opts ...Option) (*http.Client, error) {
+	if timeout > 0 {
+		opts = append([]Option{WithSingleSleepLimit(timeout, nil)}, opts...)
+	}
+	client, err := NewRateLimitWaiterClient(base, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client.Timeout = timeout
+	return client, nil
+}
+
 // RoundTrip handles the secondary rate limit by waiting for it to finish before issuing new requests.
 // If a request got a secondary rate limit error as a response, we retry the request after waiting.
 // Issuing more requests during a secondary rate limit may cause a ban from the server side,
@@ -48,61 +117,376 @@ func NewRateLimitWaiterClient(base http.RoundTripper, opts ...Option) (*http.Cli
 // after a retry-after response is received and before it is processed,
 // a few other (concurrent) requests may be issued.
 func (t *SecondaryRateLimitWaiter) RoundTrip(request *http.Request) (*http.Response, error) {
-	t.waitForRateLimit()
+	// the per-request config may contradict itself as well (see WithOverrideConfig)
+	config := t.getRequestConfig(request)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.deterministicOrdering && !isBypassed(request.Context()) {
+		t.serial.Lock()
+		defer t.serial.Unlock()
+	}
+
+	return t.roundTrip(request)
+}
+
+// roundTrip issues the request and retries it after a secondary rate limit (see RoundTrip).
+// every attempt is issued with a clone of the request, so that the caller's request is never modified.
+// the decision accumulates over the attempts, and is attached to the returned response (see GetLimitDecision).
+func (t *SecondaryRateLimitWaiter) roundTrip(request *http.Request) (*http.Response, error) {
+	decision := &LimitDecision{}
+	attempt := request.Clone(request.Context())
+	for {
+		resp, next, err := t.roundTripAttempt(attempt, decision)
+		if next == nil {
+			if config := t.getRequestConfig(request); resp != nil && config.responseModifier != nil {
+				config.responseModifier(resp)
+			}
+			return resp, err
+		}
+		attempt = next
+	}
+}
+
+// roundTripAttempt issues a single attempt of the request (see roundTrip).
+// returns the request for the next attempt if the request should be retried, or nil otherwise.
+func (t *SecondaryRateLimitWaiter) roundTripAttempt(request *http.Request, decision *LimitDecision) (*http.Response, *http.Request, error) {
+	config := t.getRequestConfig(request)
+	if isBypassed(request.Context()) {
+		resp, err := t.roundTripBypass(config, request, decision)
+		return resp, nil, err
+	}
+
+	if err := t.waitForResume(request.Context()); err != nil {
+		return nil, nil, err
+	}
+
+	waitStart := time.Now()
+	probe, leader, err := t.waitForRateLimit(request.Context(), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	sent := false
+	defer func() {
+		t.finishLeader(leader, sent)
+	}()
+	releaseSlot, err := t.acquireConcurrencySlot(request.Context())
+	if err != nil {
+		t.finishProbe(config, probe, false)
+		return nil, nil, err
+	}
+	// a rate limit may have been detected while waiting for the slot - wait for it instead of using the slot.
+	// this is checked before reserving capacity in the windows, so that restarting the attempt does not reserve it twice.
+	if probe == nil && t.slots != nil && t.isLimitActive() {
+		releaseSlot()
+		return nil, request, nil
+	}
+	releasePoints, err := t.waitForPointWindow(request.Context(), config, request)
+	if err != nil {
+		releaseSlot()
+		t.finishProbe(config, probe, false)
+		return nil, nil, err
+	}
+	releaseRequest, err := t.waitForRequestWindow(request.Context(), config, request)
+	if err != nil {
+		releasePoints()
+		releaseSlot()
+		t.finishProbe(config, probe, false)
+		return nil, nil, err
+	}
+	// a rate limit may have been detected while pacing - wait for it instead of sending the request into it.
+	// the reserved capacity is released, since the restarted attempt reserves it again.
+	if probe == nil && t.isLimitActive() {
+		releaseRequest()
+		releasePoints()
+		releaseSlot()
+		return nil, request, nil
+	}
+	waited := time.Since(waitStart)
+	decision.Waited += waited
+	if config.preSendCallback != nil {
+		config.preSendCallback(request, waited)
+	}
 
 	resp, err := t.Base.RoundTrip(request)
+	releaseSlot()
+	sent = true
 	if err != nil {
-		return resp, err
+		t.finishProbe(config, probe, false)
+		return resp, nil, err
+	}
+
+	if config.responseInspector != nil {
+		config.responseInspector(request, resp)
 	}
 
-	secondaryLimit := parseSecondaryLimitTime(resp)
+	secondaryLimit, detected := t.detectSecondaryLimit(config, request, resp)
+	var unavailable *time.Time
 	if secondaryLimit == nil {
-		return resp, nil
+		unavailable = detectServiceUnavailable(config, request, resp, decision)
+	}
+	// only a probe that is known not to be limited releases the limit
+	// (i.e., not a filtered request, nor a limit without a usable reset time).
+	t.finishProbe(config, probe, detected == detectionPassed && unavailable == nil)
+	if unavailable != nil {
+		retryRequest, err := t.backoffServiceUnavailable(config, request, resp, *unavailable, waited)
+		if err != nil {
+			return nil, nil, err
+		}
+		if retryRequest != nil {
+			decision.Retries++
+			decision.serviceUnavailableRetries++
+			return nil, retryRequest, nil
+		}
 	}
 
-	callbackContext := CallbackContext{
-		Request:  request,
-		Response: resp,
+	return t.handleResponse(config, request, resp, secondaryLimit, probe != nil, decision, waited)
+}
+
+// handleResponse decides what to do with the response, given the secondary rate limit it carries (nil if none),
+// updating the state and recording the decision. It is shared by the waiter and the Limiter.
+// returns either the response to return, the request to retry with (after waiting for the limit), or an error.
+func (t *SecondaryRateLimitWaiter) handleResponse(config *SecondaryRateLimitConfig, request *http.Request, resp *http.Response, secondaryLimit *time.Time, probe bool, decision *LimitDecision, waited time.Duration) (*http.Response, *http.Request, error) {
+	if secondaryLimit == nil {
+		config.recordDecision(request, resp, nil, DecisionPass, waited)
+		decision.Decision = DecisionPass
+		attachLimitDecision(request, resp, decision)
+		if decision.Retries > 0 && config.onRecovered != nil {
+			config.onRecovered(t.newCallbackContext(request, resp), decision.Waited)
+		}
+		return resp, nil, nil
+	}
+	decision.limited(resp, *secondaryLimit, config.getMillisecondResetThreshold())
+	if !probe {
+		t.countSlip(config, request, resp)
+	}
+
+	if config.limitAsError {
+		config.recordDecision(request, resp, secondaryLimit, DecisionError, waited)
+		decision.Decision = DecisionError
+		attachLimitDecision(request, resp, decision)
+		bufferBody(resp)
+		return nil, nil, &SecondaryRateLimitError{
+			ResetTime: *secondaryLimit,
+			Request:   request,
+			Response:  resp,
+		}
 	}
 
-	shouldRetry := t.updateRateLimit(*secondaryLimit, &callbackContext)
+	callbackContext := t.newCallbackContext(request, resp)
+	shouldRetry := t.updateRateLimit(*secondaryLimit, config, callbackContext) && config.shouldRetry(request, resp)
+	var retryRequest *http.Request
+	if shouldRetry {
+		retryRequest, shouldRetry = rewindRequest(request)
+	}
 	if !shouldRetry {
-		return resp, nil
+		config.recordDecision(request, resp, secondaryLimit, DecisionReturn, waited)
+		decision.Decision = DecisionReturn
+		attachLimitDecision(request, resp, decision)
+		return resp, nil, nil
+	}
+
+	if config.IsAboveGlobalMaxRetries(t.retries.Add(1)) {
+		config.recordDecision(request, resp, secondaryLimit, DecisionError, waited)
+		discardBody(resp)
+		return nil, nil, ErrGlobalMaxRetriesExceeded
 	}
 
-	return t.RoundTrip(request)
+	config.recordDecision(request, resp, secondaryLimit, DecisionRetry, waited)
+	discardBody(resp)
+	decision.Retries++
+	return nil, retryRequest, nil
+}
+
+// maxDiscardedBodySize is the maximal size of a discarded response body that is drained to reuse the connection.
+const maxDiscardedBodySize = 64 << 10
+
+// discardBody drains and closes the body of a response that is not returned (e.g., before a retry),
+// so that the underlying connection can be reused.
+func discardBody(resp *http.Response) {
+	if resp.Body == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDiscardedBodySize))
+	resp.Body.Close()
+}
+
+// bufferBody replaces the body of a response that is not returned as-is (e.g., within an error) with an in-memory copy,
+// and closes the original body, so that the underlying connection is released even if the copy is never closed.
+func bufferBody(resp *http.Response) {
+	if resp.Body == nil {
+		return
+	}
+	rawBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	restoreBody(resp, rawBody)
+}
+
+// rewindRequest clones the request to be sent again, since its body was consumed by the previous attempt.
+// returns false if the body cannot be reconstructed (i.e., the request has a body but no GetBody).
+func rewindRequest(request *http.Request) (*http.Request, bool) {
+	rewound := request.Clone(request.Context())
+	if request.Body == nil || request.Body == http.NoBody {
+		return rewound, true
+	}
+	if request.GetBody == nil {
+		return nil, false
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	rewound.Body = body
+	return rewound, true
+}
+
+// ResetGlobalRetries resets the retry counter used by WithGlobalMaxRetries.
+func (t *SecondaryRateLimitWaiter) ResetGlobalRetries() {
+	t.retries.Store(0)
 }
 
 func (t *SecondaryRateLimitWaiter) getRequestConfig(request *http.Request) *SecondaryRateLimitConfig {
 	overrides := GetConfigOverrides(request.Context())
+	if statusCodes, ok := GetOverrideStatusCodes(request.Context()); ok {
+		overrides = append(overrides[:len(overrides):len(overrides)], WithDetectionStatusCodes(statusCodes...))
+	}
+
+	if replacement, ok := GetConfigReplacement(request.Context()); ok {
+		reqConfig := newConfig(replacement...)
+		reqConfig.ApplyOptions(overrides...)
+		return reqConfig
+	}
+
 	if overrides == nil {
 		// no config override - use the default config (zero-copy)
-		return t.config
+		return t.config.Load()
 	}
-	reqConfig := *t.config
+	reqConfig := *t.config.Load()
 	reqConfig.ApplyOptions(overrides...)
 	return &reqConfig
 }
 
 // waitForRateLimit waits for the cooldown time to finish if a secondary rate limit is active.
-func (t *SecondaryRateLimitWaiter) waitForRateLimit() {
-	t.lock.RLock()
-	sleepDuration := t.currentSleepDurationUnlocked()
-	t.lock.RUnlock()
+// returns the probe state if the request should be issued as a probe of the active rate limit (see WithProbeRetry).
+// returns the coalescing gate if the request should lead the requests that resume after the rate limit (see WithCoalescing).
+// with fair release, the requests resume in arrival order, spaced apart (see WithFairRelease).
+// returns an error if either the request context or the root context is done.
+func (t *SecondaryRateLimitWaiter) waitForRateLimit(ctx context.Context, config *SecondaryRateLimitConfig) (*probeState, *coalesceGate, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
 
-	time.Sleep(sleepDuration)
+	// the rate limit for which a fair release slot was claimed
+	var fairLimit *time.Time
+	spacing := config.fairReleaseSpacing
+
+	for {
+		t.lock.RLock()
+		sleepDuration := t.currentSleepDurationUnlocked()
+		sleepUntil := t.sleepUntil
+		released := t.released
+		probing := t.probe != nil
+		coalescing := t.coalesce != nil
+		fairPending := spacing > 0 && t.isFairQueuePendingUnlocked(spacing)
+		t.lock.RUnlock()
+
+		if spacing > 0 && !probing && sleepUntil != fairLimit && (sleepDuration > 0 || fairPending) {
+			var delay time.Duration
+			delay, fairLimit = t.claimFairSlot(spacing)
+			if err := t.sleepWithContext(ctx, delay, released); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if sleepDuration <= 0 {
+			if !coalescing {
+				return nil, nil, nil
+			}
+
+			leader, done := t.claimLeader()
+			if done == nil {
+				return nil, leader, nil
+			}
+			if err := t.waitForLeader(ctx, done); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if !probing {
+			if err := t.sleepWithContext(ctx, sleepDuration, released); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		probe, sleepDuration := t.claimProbe()
+		if probe != nil {
+			return probe, nil, nil
+		}
+		if err := t.sleepWithContext(ctx, sleepDuration, released); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// sleepWithContext sleeps for the given duration,
+// unless either the request context or the root context is done first,
+// or the active rate limit is released early.
+// the time actually slept is subtracted from the operation budget of the request context, if any.
+func (t *SecondaryRateLimitWaiter) sleepWithContext(ctx context.Context, sleepDuration time.Duration, released <-chan struct{}) error {
+	if sleepDuration <= 0 {
+		return nil
+	}
+
+	budget := getOperationBudget(ctx)
+	if budget != nil && !budget.fits(sleepDuration) {
+		return ErrOperationBudgetExceeded
+	}
+
+	start := time.Now()
+	if budget != nil {
+		defer func() {
+			budget.consume(time.Since(start))
+		}()
+	}
+	timer := time.NewTimer(sleepDuration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-released:
+		return nil
+	case <-ctx.Done():
+		return newWaitCanceledError(ctx.Err(), start, sleepDuration)
+	case <-t.ctx.Done():
+		return newWaitCanceledError(t.ctx.Err(), start, sleepDuration)
+	}
 }
 
 // updateRateLimit updates the active rate limit and triggers user callbacks if needed.
 // the rate limit is not updated if there is already an active rate limit.
 // it never waits because the retry handles sleeping anyway.
 // returns whether or not to retry the request.
-func (t *SecondaryRateLimitWaiter) updateRateLimit(secondaryLimit time.Time, callbackContext *CallbackContext) (needRetry bool) {
+func (t *SecondaryRateLimitWaiter) updateRateLimit(secondaryLimit time.Time, config *SecondaryRateLimitConfig, callbackContext *CallbackContext) (needRetry bool) {
+	secondaryLimit = config.applyMinSleep(secondaryLimit)
+	secondaryLimit = config.applyMaxResetHorizon(secondaryLimit)
+
 	// quick check without the lock: maybe the secondary limit just passed
 	if time.Now().After(secondaryLimit) {
 		return true
 	}
 
+	// the state change callback is triggered once the lock is released
+	var stateChange *LimitEvent
+	defer func() {
+		notifyStateChange(config, stateChange)
+	}()
+
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -117,7 +501,11 @@ func (t *SecondaryRateLimitWaiter) updateRateLimit(secondaryLimit time.Time, cal
 		return true
 	}
 
-	config := t.getRequestConfig(callbackContext.Request)
+	// extend the sleep in case of repeated limits
+	if extended := t.oscillation.extendUnlocked(config, sleepDuration); extended != sleepDuration {
+		sleepDuration = extended
+		secondaryLimit = time.Now().Add(sleepDuration)
+	}
 
 	// do not sleep in case it is above the single sleep limit
 	if config.IsAboveSingleSleepLimit(sleepDuration) {
@@ -131,11 +519,34 @@ func (t *SecondaryRateLimitWaiter) updateRateLimit(secondaryLimit time.Time, cal
 		return false
 	}
 
+	// do not sleep in case it is above the sleep budget of the window
+	if t.isAboveWindowBudgetUnlocked(config, config.smoothSleepTime(sleepDuration)) {
+		t.triggerCallback(config.onWindowBudgetExceeded, callbackContext, secondaryLimit)
+		return false
+	}
+
 	// a legitimate new limit
-	t.sleepUntil = &secondaryLimit
-	t.totalSleepTime += smoothSleepTime(sleepDuration)
+	t.totalSleepTime += config.smoothSleepTime(sleepDuration)
 	t.triggerCallback(config.onLimitDetected, callbackContext, secondaryLimit)
 
+	// the callback may adjust the effective reset time
+	if adjusted := callbackContext.SleepUntil; adjusted != nil && !adjusted.Equal(secondaryLimit) {
+		t.totalSleepTime -= config.smoothSleepTime(sleepDuration)
+		secondaryLimit = *adjusted
+		if adjustedDuration := time.Until(secondaryLimit); adjustedDuration > 0 {
+			t.totalSleepTime += config.smoothSleepTime(adjustedDuration)
+		}
+	}
+
+	if config.windowSleepBudget != nil {
+		t.sleepWindow.addUnlocked(config.smoothSleepTime(time.Until(secondaryLimit)))
+	}
+	t.sleepUntil = &secondaryLimit
+	t.probe = newProbeState(config)
+	t.coalesce = newCoalesceGate(config)
+	t.released = make(chan struct{})
+	stateChange = t.emitLimitActiveUnlocked(config, t.sleepUntil)
+
 	return true
 }
 
@@ -161,17 +572,35 @@ func (t *SecondaryRateLimitWaiter) triggerCallback(callback func(*CallbackContex
 // parseSecondaryLimitTime parses the GitHub API response header,
 // looking for the secondary rate limit as defined by GitHub API documentation.
 // https://docs.github.com/en/rest/overview/resources-in-the-rest-api#secondary-rate-limits
-func parseSecondaryLimitTime(resp *http.Response) *time.Time {
-	if !isSecondaryRateLimit(resp) {
-		return nil
+// the response is assumed to be a legitimate secondary rate limit (see isSecondaryRateLimit).
+// the preference decides between the headers when both are present.
+func parseSecondaryLimitTime(resp *http.Response, preference ResetPreference, millisecondThreshold int64) *time.Time {
+	retryAfter := parseRetryAfter(resp)
+	reset := parseXRateLimitReset(resp, millisecondThreshold)
+
+	if retryAfter != nil && reset != nil {
+		switch preference {
+		case ResetPreferenceResetFirst:
+			return reset
+		case ResetPreferenceMaxOf:
+			if reset.After(*retryAfter) {
+				return reset
+			}
+			return retryAfter
+		case ResetPreferenceMinOf:
+			if reset.Before(*retryAfter) {
+				return reset
+			}
+			return retryAfter
+		}
 	}
 
-	if sleepUntil := parseRetryAfter(resp.Header); sleepUntil != nil {
-		return sleepUntil
+	if retryAfter != nil {
+		return retryAfter
 	}
 
-	if sleepUntil := parseXRateLimitReset(resp); sleepUntil != nil {
-		return sleepUntil
+	if reset != nil {
+		return reset
 	}
 
 	// XXX: per GitHub API docs, we should default to a 60 seconds sleep duration in case the header is missing,
@@ -182,8 +611,8 @@ func parseSecondaryLimitTime(resp *http.Response) *time.Time {
 }
 
 // parseRetryAfter parses the GitHub API response header in case a Retry-After is returned.
-func parseRetryAfter(header http.Header) *time.Time {
-	retryAfterSeconds, ok := httpHeaderIntValue(header, "retry-after")
+func parseRetryAfter(resp *http.Response) *time.Time {
+	retryAfterSeconds, ok := httpResponseSecondsValue(resp, HeaderRetryAfter)
 	if !ok || retryAfterSeconds <= 0 {
 		return nil
 	}
@@ -197,18 +626,63 @@ func parseRetryAfter(header http.Header) *time.Time {
 // parseXRateLimitReset parses the GitHub API response header in case a x-ratelimit-reset is returned.
 // to avoid handling primary rate limits (which are categorized),
 // we only handle x-ratelimit-reset in case the primary rate limit is not reached.
-func parseXRateLimitReset(resp *http.Response) *time.Time {
-	secondsSinceEpoch, ok := httpHeaderIntValue(resp.Header, HeaderXRateLimitReset)
+// values above the threshold are interpreted as milliseconds since epoch (see WithMillisecondResetThreshold).
+func parseXRateLimitReset(resp *http.Response, millisecondThreshold int64) *time.Time {
+	secondsSinceEpoch, ok := httpResponseIntValue(resp, HeaderXRateLimitReset)
 	if !ok || secondsSinceEpoch <= 0 {
 		return nil
 	}
 
-	// per GitHub API, the header is set to the number of seconds since epoch (UTC)
+	// per GitHub API, the header is set to the number of seconds since epoch (UTC).
+	// some proxies (and GHES builds) use milliseconds, which would otherwise pin the client for millennia.
 	sleepUntil := time.Unix(secondsSinceEpoch, 0)
+	if millisecondThreshold > 0 && secondsSinceEpoch > millisecondThreshold {
+		sleepUntil = time.UnixMilli(secondsSinceEpoch)
+	}
+
+	// a reset time in the past (e.g., due to clock drift or a stale cache) means there is no active limit
+	if !sleepUntil.After(time.Now()) {
+		return nil
+	}
 
 	return &sleepUntil
 }
 
+// httpResponseIntValue parses an integer value from the response header.
+// if the header is absent, the response trailer is consulted instead
+// (note that trailers are only populated after the body is read, see readTrailers).
+func httpResponseIntValue(resp *http.Response, key string) (int64, bool) {
+	if value, ok := httpHeaderIntValue(resp.Header, key); ok {
+		return value, true
+	}
+	return httpHeaderIntValue(resp.Trailer, key)
+}
+
+// httpResponseSecondsValue parses a number of seconds from the given HTTP response header (or trailer).
+// some gateways use fractional seconds (e.g., 1.5), which are rounded up to whole seconds.
+func httpResponseSecondsValue(resp *http.Response, key string) (int64, bool) {
+	if value, ok := httpHeaderSecondsValue(resp.Header, key); ok {
+		return value, true
+	}
+	return httpHeaderSecondsValue(resp.Trailer, key)
+}
+
+// httpHeaderSecondsValue parses a number of seconds from the given HTTP header, rounding up fractions.
+func httpHeaderSecondsValue(header http.Header, key string) (int64, bool) {
+	if value, ok := httpHeaderIntValue(header, key); ok {
+		return value, true
+	}
+	val := header.Get(key)
+	if val == "" {
+		return 0, false
+	}
+	asFloat, err := strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(asFloat) || math.IsInf(asFloat, 0) || asFloat > math.MaxInt32 {
+		return 0, false
+	}
+	return int64(math.Ceil(asFloat)), true
+}
+
 // httpHeaderIntValue parses an integer value from the given HTTP header.
 func httpHeaderIntValue(header http.Header, key string) (int64, bool) {
 	val := header.Get(key)
@@ -0,0 +1,112 @@
+package github_ratelimit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Decision is the decision of the waiter regarding a response.
+type Decision string
+
+const (
+	// DecisionPass means the response is not a secondary rate limit.
+	DecisionPass Decision = "pass"
+	// DecisionRetry means the response is a secondary rate limit, and the request is retried (after sleeping).
+	DecisionRetry Decision = "retry"
+	// DecisionReturn means the response is a secondary rate limit, but it is returned as-is (e.g., above the sleep limits).
+	DecisionReturn Decision = "return"
+	// DecisionError means the response is a secondary rate limit, and an error is returned instead.
+	DecisionError Decision = "error"
+)
+
+// SessionEntry records a single round trip through the waiter.
+type SessionEntry struct {
+	Time       time.Time     `json:"time"`
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	StatusCode int           `json:"status_code"`
+	Header     http.Header   `json:"header"`
+	Body       []byte        `json:"body"`
+	ResetTime  *time.Time    `json:"reset_time,omitempty"`
+	Decision   Decision      `json:"decision"`
+	Waited     time.Duration `json:"waited"`
+}
+
+// SessionRecorder records the responses and the decisions of the waiter (see WithSessionRecorder).
+// The recorded session can be fed back through a waiter using NewSessionReplayer, for debugging.
+type SessionRecorder struct {
+	lock    sync.Mutex
+	entries []SessionEntry
+}
+
+func NewSessionRecorder() *SessionRecorder {
+	return &SessionRecorder{}
+}
+
+// Entries returns a copy of the recorded entries, in order.
+func (r *SessionRecorder) Entries() []SessionEntry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]SessionEntry(nil), r.entries...)
+}
+
+// record adds an entry for the response. the response body is read and restored.
+func (r *SessionRecorder) record(request *http.Request, resp *http.Response, resetTime *time.Time, decision Decision, waited time.Duration) {
+	var rawBody []byte
+	if resp.Body != nil {
+		rawBody, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		restoreBody(resp, rawBody)
+	}
+
+	entry := SessionEntry{
+		Time:       time.Now(),
+		Method:     request.Method,
+		URL:        request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       rawBody,
+		ResetTime:  resetTime,
+		Decision:   decision,
+		Waited:     waited,
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// SessionReplayer is a round tripper that replays the responses of a recorded session, in order.
+// Use it as the base of a waiter to feed the recorded responses back through the waiter deterministically.
+type SessionReplayer struct {
+	lock    sync.Mutex
+	entries []SessionEntry
+}
+
+func NewSessionReplayer(entries []SessionEntry) *SessionReplayer {
+	return &SessionReplayer{
+		entries: entries,
+	}
+}
+
+func (r *SessionReplayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.entries) == 0 {
+		return nil, ErrSessionExhausted
+	}
+	entry := r.entries[0]
+	r.entries = r.entries[1:]
+
+	return &http.Response{
+		StatusCode:    entry.StatusCode,
+		Header:        entry.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       request,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package github_ratelimit
+
+import (
+	"time"
+)
+
+// SetSingleSleepLimit changes the single sleep limit of the waiter at runtime (see WithSingleSleepLimit),
+// e.g., to tighten it during an incident without recreating the waiter.
+// Attempts that are already in-flight keep using the config they started with.
+func (t *SecondaryRateLimitWaiter) SetSingleSleepLimit(limit time.Duration, callback OnSingleLimitExceeded) error {
+	return t.updateConfig(WithSingleSleepLimit(limit, callback))
+}
+
+// SetTotalSleepLimit changes the total sleep limit of the waiter at runtime (see WithTotalSleepLimit).
+// Attempts that are already in-flight keep using the config they started with.
+func (t *SecondaryRateLimitWaiter) SetTotalSleepLimit(limit time.Duration, callback OnTotalLimitExceeded) error {
+	return t.updateConfig(WithTotalSleepLimit(limit, callback))
+}
+
+// updateConfig applies the options to a copy of the config and swaps it in (unless the result is invalid),
+// so that every attempt reads a consistent snapshot of the config.
+func (t *SecondaryRateLimitWaiter) updateConfig(opts ...Option) error {
+	t.configLock.Lock()
+	defer t.configLock.Unlock()
+
+	config := *t.config.Load()
+	config.ApplyOptions(opts...)
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	t.config.Store(&config)
+	return nil
+}
@@ -0,0 +1,47 @@
+package github_ratelimit
+
+import (
+	"time"
+)
+
+// windowSleep is a sleep accounted by the sleep budget per window.
+type windowSleep struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// sleepWindow accounts the sleeps within a rolling time window (see WithSleepBudgetPerWindow).
+type sleepWindow struct {
+	sleeps []windowSleep
+}
+
+// totalUnlocked returns the accumulated sleep duration within the window, dropping the sleeps that aged out.
+// Note: expects the lock to be held.
+func (w *sleepWindow) totalUnlocked(window time.Duration) time.Duration {
+	now := time.Now()
+	recent := w.sleeps[:0]
+	var total time.Duration
+	for _, sleep := range w.sleeps {
+		if now.Sub(sleep.at) < window {
+			recent = append(recent, sleep)
+			total += sleep.duration
+		}
+	}
+	w.sleeps = recent
+	return total
+}
+
+// addUnlocked accounts a new sleep.
+// Note: expects the lock to be held.
+func (w *sleepWindow) addUnlocked(duration time.Duration) {
+	w.sleeps = append(w.sleeps, windowSleep{at: time.Now(), duration: duration})
+}
+
+// isAboveWindowBudgetUnlocked returns true if the sleep would exceed the sleep budget of the window.
+// Note: expects the lock to be held.
+func (t *SecondaryRateLimitWaiter) isAboveWindowBudgetUnlocked(config *SecondaryRateLimitConfig, sleepDuration time.Duration) bool {
+	if config.windowSleepBudget == nil {
+		return false
+	}
+	return t.sleepWindow.totalUnlocked(config.sleepBudgetWindow)+sleepDuration > *config.windowSleepBudget
+}
@@ -0,0 +1,32 @@
+package github_ratelimit
+
+import (
+	"net/http"
+)
+
+// Stats is a snapshot of the counters of the waiter.
+type Stats struct {
+	// Slipped is the number of requests that got a secondary rate limit response while a limit was already active,
+	// i.e., requests that were sent (concurrently) before the active limit was detected.
+	// Probes (see WithProbeRetry) are not counted, as they are sent during the limit on purpose.
+	Slipped int64
+}
+
+// Stats returns a snapshot of the counters of the waiter.
+func (t *SecondaryRateLimitWaiter) Stats() Stats {
+	return Stats{
+		Slipped: t.slipped.Load(),
+	}
+}
+
+// countSlip counts a request that got a secondary rate limit response while a limit was already active (see Stats).
+func (t *SecondaryRateLimitWaiter) countSlip(config *SecondaryRateLimitConfig, request *http.Request, resp *http.Response) {
+	if !t.isLimitActive() {
+		return
+	}
+
+	t.slipped.Add(1)
+	if config.onSlipped != nil {
+		config.onSlipped(t.newCallbackContext(request, resp))
+	}
+}
@@ -0,0 +1,46 @@
+package github_ratelimit
+
+import (
+	"net/http"
+	"time"
+)
+
+// detectServiceUnavailable returns the time to back off until, if the response is a transient service unavailability
+// (i.e., a 503 with a retry-after header) that should be retried (see WithServiceUnavailableBackoff).
+// the detection is distinct from the secondary rate limit detection, and does not affect the state of the waiter.
+func detectServiceUnavailable(config *SecondaryRateLimitConfig, request *http.Request, resp *http.Response, decision *LimitDecision) *time.Time {
+	if config.serviceUnavailableMaxRetries <= 0 || decision.serviceUnavailableRetries >= config.serviceUnavailableMaxRetries {
+		return nil
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header == nil || config.isRequestFiltered(request) {
+		return nil
+	}
+	return parseRetryAfter(resp)
+}
+
+// backoffServiceUnavailable waits until the given time and returns the request to retry,
+// or nil if the request should not be retried (in which case, the response is returned as-is).
+// the wait is limited by the single sleep limit, and applies only to the request itself.
+func (t *SecondaryRateLimitWaiter) backoffServiceUnavailable(config *SecondaryRateLimitConfig, request *http.Request, resp *http.Response, until time.Time, waited time.Duration) (*http.Request, error) {
+	sleepDuration := time.Until(until)
+	if config.IsAboveSingleSleepLimit(sleepDuration) || !config.shouldRetry(request, resp) {
+		return nil, nil
+	}
+	retryRequest, ok := rewindRequest(request)
+	if !ok {
+		return nil, nil
+	}
+
+	if config.onServiceUnavailable != nil {
+		callbackContext := t.newCallbackContext(request, resp)
+		callbackContext.SleepUntil = &until
+		config.onServiceUnavailable(callbackContext)
+	}
+
+	config.recordDecision(request, resp, &until, DecisionRetry, waited)
+	discardBody(resp)
+	if err := t.sleepWithContext(request.Context(), sleepDuration, nil); err != nil {
+		return nil, err
+	}
+	return retryRequest, nil
+}